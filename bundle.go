@@ -0,0 +1,74 @@
+package templatestatic
+
+import (
+	"fmt"
+	"html/template"
+	"slices"
+)
+
+// A bundle is a static assembled from other statics, in member order.
+type bundle struct {
+	name    string
+	members []string
+}
+
+// checkBundles validates the bundles configured for t.
+func checkBundles(t *template.Template, cfg *config) error {
+	seen := make(map[string]bool)
+	owner := make(map[string]string)
+	for _, b := range cfg.bundles {
+		k, ok := cfg.kindOf(b.name)
+		if !ok {
+			return fmt.Errorf("templatestatic: bundle %q is not a static name", b.name)
+		}
+		if !safeSuffix(b.name[len(k.prefix):]) {
+			return fmt.Errorf("templatestatic: bundle %q does not map to a file inside outputDir", b.name)
+		}
+		if t.Lookup(b.name) != nil {
+			return fmt.Errorf("templatestatic: bundle %q is also defined as a template", b.name)
+		}
+		if seen[b.name] {
+			return fmt.Errorf("templatestatic: bundle %q declared twice", b.name)
+		}
+		seen[b.name] = true
+		if len(b.members) == 0 {
+			return fmt.Errorf("templatestatic: bundle %q has no members", b.name)
+		}
+		for _, m := range b.members {
+			if mk, ok := cfg.kindOf(m); !ok || mk.kind != k.kind {
+				return fmt.Errorf("templatestatic: bundle %q: member %q is not a %s static", b.name, m, k.kind)
+			}
+			if t.Lookup(m) == nil {
+				return fmt.Errorf("templatestatic: bundle %q: member %q is not defined", b.name, m)
+			}
+			if prev, ok := owner[m]; ok {
+				return fmt.Errorf("templatestatic: %q is a member of both %q and %q", m, prev, b.name)
+			}
+			owner[m] = b.name
+		}
+	}
+	return nil
+}
+
+// joinMembers concatenates the rendered members of b in declared order,
+// ending each with a newline so adjacent scripts and rules stay separate. It
+// reports false if any member was not rendered.
+func joinMembers(b bundle, rendered map[string][]byte) ([]byte, bool) {
+	var out []byte
+	for _, m := range b.members {
+		content, ok := rendered[m]
+		if !ok {
+			return nil, false
+		}
+		out = append(out, content...)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			out = append(out, '\n')
+		}
+	}
+	return out, true
+}
+
+// isBundle reports whether name is one of the bundles configured in cfg.
+func isBundle(cfg *config, name string) bool {
+	return slices.ContainsFunc(cfg.bundles, func(b bundle) bool { return b.name == name })
+}
@@ -0,0 +1,112 @@
+package templatestatic
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTemplateBundle = `{{define "static-js-z"}}var z = 1;{{end}}
+{{define "static-js-a"}}var a = z;
+{{end}}
+{{define "static-js-m"}}var m = a;{{end}}
+{{define "static-js-solo"}}solo();{{end}}
+{{define "page"}}<html><head>
+</head><body>{{template "static-js-a"}}</body></html>{{end}}`
+
+func TestWithBundle(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateBundle))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static",
+		WithBundle("static-js-vendor", "static-js-z", "static-js-a", "static-js-m"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	var names []string
+	for _, a := range res.Assets {
+		names = append(names, a.Name)
+	}
+	if got, want := strings.Join(names, " "), "static-js-solo static-js-vendor"; got != want {
+		t.Errorf("assets = %s, want %s", got, want)
+	}
+	for _, member := range []string{"z.js", "a.js", "m.js"} {
+		if _, err := os.Stat(filepath.Join(outDir, member)); !os.IsNotExist(err) {
+			t.Errorf("member %s written on its own", member)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(outDir, "vendor.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "var z = 1;\nvar a = z;\nvar m = a;\n"; string(got) != want {
+		t.Errorf("vendor.js = %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, `<script src="/static/vendor.js"></script>`); n != 1 {
+		t.Errorf("bundle tag rendered %d times, want 1\n%s", n, out)
+	}
+	if strings.Contains(out, "var a") {
+		t.Errorf("member call rendered its content\n%s", out)
+	}
+}
+
+// The bundle's bytes and hash depend only on the declared member order, not
+// on template iteration order, so repeated builds are reproducible.
+func TestWithBundleReproducible(t *testing.T) {
+	var hashes []string
+	var contents [][]byte
+	for i := 0; i < 20; i++ {
+		tmpl := template.Must(template.New("test").Parse(testTemplateBundle))
+		res, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint(),
+			WithBundle("static-js-vendor", "static-js-z", "static-js-a", "static-js-m"))
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		for _, a := range res.Assets {
+			if a.Name == "static-js-vendor" {
+				hashes = append(hashes, a.Hash)
+				contents = append(contents, res.contents[a.File])
+			}
+		}
+	}
+	for i := range hashes {
+		if hashes[i] != hashes[0] || !bytes.Equal(contents[i], contents[0]) {
+			t.Fatalf("build %d: bundle hash %s, want %s", i, hashes[i], hashes[0])
+		}
+	}
+}
+
+func TestWithBundleInvalid(t *testing.T) {
+	tests := map[string][]Option{
+		"not a static":     {WithBundle("vendor", "static-js-a")},
+		"defined":          {WithBundle("static-js-solo", "static-js-a")},
+		"no members":       {WithBundle("static-js-vendor")},
+		"undefined member": {WithBundle("static-js-vendor", "static-js-nope")},
+		"wrong kind":       {WithBundle("static-css-vendor", "static-js-a")},
+		"unsafe name":      {WithBundle("static-js-../vendor", "static-js-a")},
+		"shared member": {
+			WithBundle("static-js-v1", "static-js-a"),
+			WithBundle("static-js-v2", "static-js-m", "static-js-a"),
+		},
+		"declared twice": {
+			WithBundle("static-js-v1", "static-js-a"),
+			WithBundle("static-js-v1", "static-js-m"),
+		},
+	}
+	for name, opts := range tests {
+		tmpl := template.Must(template.New("test").Parse(testTemplateBundle))
+		if _, err := Build(tmpl, nil, t.TempDir(), "/static", opts...); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package templatestatic
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"text/template/parse"
+)
+
+// CheckDuplicates parses the files in fsys matching patterns, as ParseFS
+// would, and returns an error for each static definition given a non-empty
+// body in more than one of them.
+//
+// A template set keeps only the last definition of a name: ParseFS and
+// ParseGlob parse files one at a time and let a later file replace an earlier
+// definition, so by the time Parse sees the set the others are gone and it
+// cannot report them. Call CheckDuplicates on the same files to catch
+// accidental redefinitions. A name defined twice within one file is already
+// an error from html/template.
+//
+// Of opts, WithKinds and WithDelims apply: the former names more statics, the
+// latter sets the delimiters files are parsed with, which default to {{ and
+// }}. Funcs are not checked.
+func CheckDuplicates(fsys fs.FS, patterns []string, opts ...Option) error {
+	cfg := newConfig(opts)
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return fmt.Errorf("templatestatic: %w", err)
+		}
+		files = append(files, matches...)
+	}
+
+	defined := make(map[string][]string) // static name -> files defining it
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("templatestatic: %w", err)
+		}
+		trees := make(map[string]*parse.Tree)
+		t := parse.New(path.Base(file))
+		t.Mode = parse.SkipFuncCheck
+		if _, err := t.Parse(string(data), cfg.leftDelim, cfg.rightDelim, trees); err != nil {
+			return fmt.Errorf("templatestatic: %w", err)
+		}
+		for name, tree := range trees {
+			if _, ok := cfg.kindOf(name); ok && !parse.IsEmptyTree(tree.Root) {
+				defined[name] = append(defined[name], file)
+			}
+		}
+	}
+
+	var errs []error
+	for name, in := range defined {
+		if len(in) > 1 {
+			errs = append(errs, fmt.Errorf("templatestatic: %q is defined in %d files: %v; only the last is used", name, len(in), in))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
@@ -0,0 +1,56 @@
+package templatestatic
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheckDuplicates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.html":    {Data: []byte(`{{define "static-css-foo"}}a{}{{end}}{{define "page"}}<html><head></head></html>{{end}}`)},
+		"b.html":    {Data: []byte(`{{define "static-css-foo"}}b{}{{end}}{{define "static-js-app"}}{{helper}}{{end}}`)},
+		"c.html":    {Data: []byte(`{{define "static-js-app"}}{{end}}`)}, // empty: does not replace
+		"notes.txt": {Data: []byte(`{{define "static-css-foo"}}c{}{{end}}`)},
+	}
+
+	err := CheckDuplicates(fsys, []string{"*.html"})
+	if err == nil {
+		t.Fatal("CheckDuplicates: expected error")
+	}
+	if want := `"static-css-foo" is defined in 2 files: [a.html b.html]`; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %s", err, want)
+	}
+	if strings.Contains(err.Error(), "static-js-app") {
+		t.Errorf("error reports an empty redefinition: %v", err)
+	}
+	if err := CheckDuplicates(fsys, []string{"a.html", "c.html"}); err != nil {
+		t.Errorf("CheckDuplicates without duplicates: %v", err)
+	}
+
+	// Parse itself sees only the last definition.
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{"helper": func() string { return "" }}).ParseFS(fsys, "*.html"))
+	res, err := Build(tmpl, nil, t.TempDir(), "/static", WithoutInjection())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if got, _ := fs.ReadFile(res.FS(), "foo.css"); !bytes.Equal(got, []byte("b{}")) {
+		t.Errorf("foo.css = %q, want the last definition", got)
+	}
+}
+
+func TestCheckDuplicatesOptions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.html": {Data: []byte(`[[define "vendor"]]a{}[[end]][[define "static-css-x"]]x{}[[end]]`)},
+		"b.html": {Data: []byte(`[[define "vendor"]]b{}[[end]][[define "static-css-x"]]y{}[[end]]`)},
+	}
+	err := CheckDuplicates(fsys, []string{"*.html"}, WithDelims("[[", "]]"), WithKinds(map[string]Kind{"vendor": KindCSS}))
+	for _, name := range []string{`"vendor"`, `"static-css-x"`} {
+		if err == nil || !strings.Contains(err.Error(), name+" is defined in 2 files") {
+			t.Errorf("error = %v, want it to report %s", err, name)
+		}
+	}
+}
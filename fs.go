@@ -0,0 +1,160 @@
+package templatestatic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// FS returns the generated assets as a read-only file system with the same
+// layout Build wrote to outputDir: each asset at its AssetInfo.File path, plus
+// any source maps, except assets written with WithStreamingWrites, which are
+// not kept in memory and are absent. It reflects the in-memory content of this
+// Result, so it stays consistent with Assets even if outputDir is later
+// changed on disk. With WithDestination, assets of every kind appear together
+// at their File paths.
+func (r *Result) FS() fs.FS {
+	children := map[string]map[string]bool{".": {}}
+	m := &memFS{files: make(map[string][]byte), dirs: make(map[string][]string)}
+	for file, data := range r.contents {
+		m.files[file] = data
+		for child := file; child != "."; child = path.Dir(child) {
+			dir := path.Dir(child)
+			if children[dir] == nil {
+				children[dir] = make(map[string]bool)
+			}
+			children[dir][path.Base(child)] = true
+		}
+	}
+	for dir, names := range children {
+		for name := range names {
+			m.dirs[dir] = append(m.dirs[dir], name)
+		}
+		sort.Strings(m.dirs[dir])
+	}
+	return m
+}
+
+// checkEmbedded returns an error for each file statics would write, source
+// maps included, that fsys lacks or holds different content for. Files are
+// looked up at their File paths.
+func checkEmbedded(fsys fs.FS, statics []staticDef) error {
+	var errs []error
+	check := func(name, file string, content []byte) {
+		got, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("templatestatic: %q: %s is not embedded: %w", name, file, err))
+		} else if !bytes.Equal(got, content) {
+			errs = append(errs, fmt.Errorf("templatestatic: %q: embedded %s differs from the rendered content; regenerate it", name, file))
+		}
+	}
+	for _, s := range statics {
+		if s.inline || s.shared {
+			continue
+		}
+		check(s.name, s.filename, s.content)
+		if s.sourceMap != nil {
+			check(s.name, s.mapFile(), s.sourceMap)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// memFS is an immutable in-memory fs.FS.
+type memFS struct {
+	files map[string][]byte
+	dirs  map[string][]string // directory -> sorted child names
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if data, ok := m.files[name]; ok {
+		return &memFile{info: m.info(name), Reader: bytes.NewReader(data)}, nil
+	}
+	if _, ok := m.dirs[name]; ok {
+		return &memDir{fs: m, name: name, info: m.info(name)}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+	return bytes.Clone(data), nil
+}
+
+func (m *memFS) info(name string) *memInfo {
+	data, isFile := m.files[name]
+	return &memInfo{name: path.Base(name), size: int64(len(data)), dir: !isFile}
+}
+
+type memFile struct {
+	info *memInfo
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memDir struct {
+	fs     *memFS
+	name   string
+	info   *memInfo
+	offset int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := d.fs.dirs[d.name][d.offset:]
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	d.offset += len(names)
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(d.fs.info(path.Join(d.name, name)))
+	}
+	return entries, nil
+}
+
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) ModTime() time.Time { return time.Time{} }
+func (i *memInfo) IsDir() bool        { return i.dir }
+func (i *memInfo) Sys() any           { return nil }
+
+func (i *memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+var _ fs.ReadFileFS = (*memFS)(nil)
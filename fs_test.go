@@ -0,0 +1,91 @@
+package templatestatic
+
+import (
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestResultFS(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}body{}{{end}}
+{{define "static-css-themes/dark"}}html{}{{end}}
+{{define "static-js-app"}}go(){{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithFingerprint())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	var files []string
+	for _, a := range res.Assets {
+		files = append(files, a.File)
+	}
+	fsys := res.FS()
+	if err := fstest.TestFS(fsys, files...); err != nil {
+		t.Fatal(err)
+	}
+
+	// The in-memory FS matches what was written to disk, file for file.
+	disk := os.DirFS(outDir)
+	err = fs.WalkDir(disk, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		want, _ := fs.ReadFile(disk, name)
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("%s on disk but not in FS: %v", name, err)
+		} else if string(got) != string(want) {
+			t.Errorf("%s: FS content %q, disk content %q", name, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithEmbedded(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	embedded := fstest.MapFS{
+		"main.css": {Data: []byte("body { color: red; }")},
+		"app.js":   {Data: []byte(`console.log("hi");`)},
+	}
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithEmbedded(embedded), WithManifest("manifest.json"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		if _, err := os.Stat(filepath.Join(outDir, a.File)); !os.IsNotExist(err) {
+			t.Errorf("%s written despite WithEmbedded", a.File)
+		}
+		if a.Size != int64(len(embedded[a.File].Data)) {
+			t.Errorf("%s: Size = %d, want %d", a.File, a.Size, len(embedded[a.File].Data))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "manifest.json")); err != nil {
+		t.Errorf("manifest not written: %v", err)
+	}
+	var buf strings.Builder
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<link rel="stylesheet" href="/static/main.css">`) {
+		t.Errorf("tag not injected:\n%s", buf.String())
+	}
+
+	stale := fstest.MapFS{"main.css": {Data: []byte("body { color: blue; }")}}
+	_, err = Build(tmpl, nil, t.TempDir(), "/static", WithEmbedded(stale))
+	for _, want := range []string{`"static-css-main": embedded main.css differs`, `"static-js-app": app.js is not embedded`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want %s", err, want)
+		}
+	}
+}
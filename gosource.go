@@ -0,0 +1,68 @@
+package templatestatic
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// writeGoConstants writes a gofmt'd Go source file to path, in package pkg,
+// declaring a string constant with the URL of each asset, leaving out inline
+// assets. Constants are named after logical names and sorted by them.
+func writeGoConstants(path, pkg string, assets []AssetInfo) error {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by templatestatic. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("// URLs of the generated static assets.\nconst (\n")
+	seen := make(map[string]string, len(assets))
+	for _, a := range assets {
+		if a.File == "" {
+			continue // inline, nothing to reference
+		}
+		ident := goIdent(a.Logical)
+		if prev, ok := seen[ident]; ok {
+			return fmt.Errorf("templatestatic: %q and %q both map to Go constant %s", prev, a.Logical, ident)
+		}
+		seen[ident] = a.Logical
+		fmt.Fprintf(&b, "%s = %s\n", ident, strconv.Quote(a.URL))
+	}
+	b.WriteString(")\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("templatestatic: Go constants: %w", err)
+	}
+	if _, err := writeIfChanged(path, src); err != nil {
+		return fmt.Errorf("templatestatic: writing Go constants %s: %w", path, err)
+	}
+	return nil
+}
+
+// goIdent returns an exported Go identifier for a logical name: each run of
+// letters and digits is capitalized, "css" and "js" are upper-cased as
+// initialisms, and everything else is dropped, so "admin/app-shell.js" is
+// AdminAppShellJS. A name that would start with a digit gets an Asset prefix.
+func goIdent(logical string) string {
+	words := strings.FieldsFunc(logical, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, w := range words {
+		switch strings.ToLower(w) {
+		case "css", "js":
+			b.WriteString(strings.ToUpper(w))
+		default:
+			r := []rune(w)
+			b.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+		}
+	}
+	ident := b.String()
+	if ident == "" || !token.IsIdentifier(ident) {
+		ident = "Asset" + ident
+	}
+	return ident
+}
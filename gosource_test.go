@@ -0,0 +1,92 @@
+package templatestatic
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithGoConstants(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}body{}{{end}}
+{{define "static-js-admin/app-shell"}}go(){{end}}
+{{define "static-css-critical"}}h1{}{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	goFile := filepath.Join(t.TempDir(), "assets", "urls.go")
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint(),
+		WithInline("static-css-critical"), WithGoConstants(goFile, "assets")); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	src, err := os.ReadFile(goFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, goFile, src, 0)
+	if err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, src)
+	}
+	pkg, err := new(types.Config).Check("assets", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatalf("generated file does not compile: %v\n%s", err, src)
+	}
+	if got := pkg.Name(); got != "assets" {
+		t.Errorf("package = %s, want assets", got)
+	}
+	consts := map[string]string{"MainCSS": "/static/main.", "AdminAppShellJS": "/static/admin/app-shell."}
+	for name, prefix := range consts {
+		c, ok := pkg.Scope().Lookup(name).(*types.Const)
+		if !ok {
+			t.Errorf("constant %s missing\n%s", name, src)
+			continue
+		}
+		if v := c.Val().ExactString(); !strings.HasPrefix(v, `"`+prefix) {
+			t.Errorf("%s = %s, want a fingerprinted URL starting with %s", name, v, prefix)
+		}
+	}
+	if got := len(pkg.Scope().Names()); got != len(consts) {
+		t.Errorf("%d constants, want %d (inline assets left out)\n%s", got, len(consts), src)
+	}
+	if strings.Count(string(src), "// Code generated") != 1 {
+		t.Errorf("missing generated-code header\n%s", src)
+	}
+}
+
+func TestWithGoConstantsErrors(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(`{{define "static-css-a-b"}}a{{end}}{{define "static-css-a_b"}}b{{end}}`))
+	goFile := filepath.Join(t.TempDir(), "urls.go")
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithGoConstants(goFile, "assets")); err == nil {
+		t.Error("expected error for two logical names with the same constant")
+	}
+	for _, pkg := range []string{"", "_", "my-assets", "1x"} {
+		if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithGoConstants(goFile, pkg)); err == nil {
+			t.Errorf("package %q: expected error", pkg)
+		}
+	}
+	if _, err := Build(tmpl, nil, filepath.Join(t.TempDir(), "static"), "/static", WithGoConstants("../urls.go", "assets")); err == nil {
+		t.Error("expected error for a relative Go file outside outputDir")
+	}
+}
+
+func TestGoIdent(t *testing.T) {
+	tests := map[string]string{
+		"main.css":         "MainCSS",
+		"main.min.css":     "MainMinCSS",
+		"app-3.js":         "App3JS",
+		"admin/app.js":     "AdminAppJS",
+		"3d.css":           "Asset3dCSS",
+		"vendor/jquery.js": "VendorJqueryJS",
+	}
+	for in, want := range tests {
+		if got := goIdent(in); got != want {
+			t.Errorf("goIdent(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
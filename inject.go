@@ -0,0 +1,417 @@
+package templatestatic
+
+import (
+	"bytes"
+	"html/template"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// A splicer inserts tags into text at its injection point and reports whether
+// it found one. st is the HTML context text starts in.
+type splicer func(text []byte, tags []string, st htmlState) ([]byte, bool)
+
+// splicer returns the splicer for the configured injection position.
+func (c *config) splicer() splicer {
+	switch {
+	case c.marker != "":
+		return atMarker(c.marker)
+	case c.afterHeadOpen && c.tidy:
+		return tidyAfterHeadOpen
+	case c.afterHeadOpen:
+		return afterHeadOpen
+	default:
+		return c.beforeClose("</head>")
+	}
+}
+
+// beforeClose returns the splicer for injecting before the end tag end.
+func (c *config) beforeClose(end string) splicer {
+	if c.tidy {
+		return tidyBeforeClose(end)
+	}
+	return beforeClose(end)
+}
+
+// InjectTags splices tags into t, in place, exactly as Parse injects the tags
+// of statics without an explicit placement: one per line at the first
+// injection point found in the text of any template associated with t. t and
+// the templates it reaches through {{template}} calls are searched first,
+// depth first in source order, then the rest by name. The injection point is
+// before </head> unless opts include WithInjectAfterHeadOpen or
+// WithInjectMarker, and WithTidyInjection and WithInjectInto apply as for
+// Parse; other options are ignored. Tags are inserted verbatim as template
+// text and are not escaped.
+//
+// InjectTags reports whether an injection point was found. It must be called
+// before t is executed.
+func InjectTags(t *template.Template, tags []string, opts ...Option) bool {
+	c := newConfig(opts)
+	return inject(t, tags, c.splicer(), c.injectInto)
+}
+
+// inject finds the first injection point in any text node across all
+// templates, taken in injectionOrder, and splices tags there. If only is
+// non-empty, just those templates are searched, in that order. It reports
+// whether it found one.
+func inject(t *template.Template, tags []string, splice splicer, only []string) bool {
+	tmpls := injectionOrder(t)
+	if len(only) > 0 {
+		tmpls = nil
+		for _, name := range only {
+			if tmpl := t.Lookup(name); tmpl != nil {
+				tmpls = append(tmpls, tmpl)
+			}
+		}
+	}
+	for _, tmpl := range tmpls {
+		if tmpl.Tree == nil {
+			continue
+		}
+		var st htmlState
+		if injectInList(tmpl.Tree.Root, tags, splice, &st) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectionOrder returns the templates associated with t in the order an
+// injection point is searched for: first t and the templates it reaches
+// through {{template}} calls, depth first in source order, so a page's own
+// </head> wins even if it comes from an included template; then the rest by
+// name. The result does not depend on the order templates were parsed in.
+func injectionOrder(t *template.Template) []*template.Template {
+	var order []*template.Template
+	seen := make(map[string]bool)
+	var visit func(tmpl *template.Template)
+	visit = func(tmpl *template.Template) {
+		if tmpl == nil || seen[tmpl.Name()] {
+			return
+		}
+		seen[tmpl.Name()] = true
+		order = append(order, tmpl)
+		if tmpl.Tree == nil {
+			return
+		}
+		walkTree(tmpl.Tree.Root, func(n parse.Node) {
+			if tn, ok := n.(*parse.TemplateNode); ok {
+				visit(t.Lookup(tn.Name))
+			}
+		})
+	}
+	visit(t)
+	for _, tmpl := range sortedTemplates(t) {
+		if !seen[tmpl.Name()] {
+			order = append(order, tmpl)
+		}
+	}
+	return order
+}
+
+// sortedTemplates returns the templates associated with t sorted by name.
+func sortedTemplates(t *template.Template) []*template.Template {
+	tmpls := t.Templates()
+	sort.Slice(tmpls, func(i, j int) bool { return tmpls[i].Name() < tmpls[j].Name() })
+	return tmpls
+}
+
+// injectInList splices tags at the first injection point in list. st carries
+// the HTML context from one text node to the next, so a <style> opened in one
+// node is still open in the next; branches are scanned as if both ran.
+func injectInList(list *parse.ListNode, tags []string, splice splicer, st *htmlState) bool {
+	if list == nil {
+		return false
+	}
+	for _, n := range list.Nodes {
+		if tn, ok := n.(*parse.TextNode); ok {
+			if text, ok := splice(tn.Text, tags, *st); ok {
+				tn.Text = text
+				return true
+			}
+			*st = st.advance(tn.Text)
+		} else if b := branch(n); b != nil {
+			if injectInList(b.List, tags, splice, st) || injectInList(b.ElseList, tags, splice, st) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// beforeClose returns a splicer that inserts tags, one per indented line,
+// before the end tag end, such as "</head>".
+func beforeClose(end string) splicer {
+	return func(text []byte, tags []string, st htmlState) ([]byte, bool) {
+		i := endTagIndex(text, end, st)
+		if i < 0 {
+			return nil, false
+		}
+		eol := lineEnding(text)
+		var injection []byte
+		if i == 0 || text[i-1] != '\n' {
+			injection = append(injection, eol...)
+		}
+		for _, tag := range tags {
+			injection = append(injection, ("  " + tag + eol)...)
+		}
+		return splice(text, i, i, injection), true
+	}
+}
+
+// afterHeadOpen splices tags, one per indented line, right after the <head>
+// start tag, which may carry attributes.
+func afterHeadOpen(text []byte, tags []string, st htmlState) ([]byte, bool) {
+	i := headOpenEnd(text, st)
+	if i < 0 {
+		return nil, false
+	}
+	eol := lineEnding(text)
+	var injection []byte
+	for _, tag := range tags {
+		injection = append(injection, (eol + "  " + tag)...)
+	}
+	if !bytes.HasPrefix(text[i:], []byte(eol)) {
+		injection = append(injection, eol...)
+	}
+	return splice(text, i, i, injection), true
+}
+
+// tidyBeforeClose is like beforeClose but first collapses the whitespace
+// before end, and indents the tags one level deeper than end's line:
+// "<title>T</title>\n\n\n  </head>" gets "\n    tag\n  </head>" after the title.
+func tidyBeforeClose(end string) splicer {
+	return func(text []byte, tags []string, st htmlState) ([]byte, bool) {
+		i := endTagIndex(text, end, st)
+		if i < 0 {
+			return nil, false
+		}
+		j := len(bytes.TrimRight(text[:i], " \t\r\n"))
+		indent := trailingIndent(text[j:i])
+		eol := lineEnding(text)
+		var injection []byte
+		if j > 0 {
+			injection = append(injection, eol...)
+		}
+		for _, tag := range tags {
+			injection = append(injection, (indent + "  " + tag + eol)...)
+		}
+		injection = append(injection, indent...)
+		return splice(text, j, i, injection), true
+	}
+}
+
+// tidyAfterHeadOpen is like afterHeadOpen but collapses the whitespace after
+// the start tag, and indents the tags like the element that follows it.
+func tidyAfterHeadOpen(text []byte, tags []string, st htmlState) ([]byte, bool) {
+	i := headOpenEnd(text, st)
+	if i < 0 {
+		return nil, false
+	}
+	j := len(text) - len(bytes.TrimLeft(text[i:], " \t\r\n"))
+	indent := trailingIndent(text[i:j])
+	if indent == "" {
+		indent = "  "
+	}
+	eol := lineEnding(text)
+	var injection []byte
+	for _, tag := range tags {
+		injection = append(injection, (eol + indent + tag)...)
+	}
+	injection = append(injection, (eol + indent)...)
+	return splice(text, i, j, injection), true
+}
+
+// lineEnding returns "\r\n" if text uses CRLF line endings, so injected
+// lines match their neighbours, and "\n" otherwise.
+func lineEnding(text []byte) string {
+	if bytes.Contains(text, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// bom is the UTF-8 byte order mark, which editors may put at the start of a
+// template file and which is not indentation.
+var bom = []byte("\ufeff")
+
+// trailingIndent returns the spaces and tabs after the last newline in ws,
+// which holds only whitespace, or "" if it has no newline.
+func trailingIndent(ws []byte) string {
+	n := bytes.LastIndexByte(ws, '\n')
+	if n < 0 {
+		return ""
+	}
+	return strings.TrimRight(string(ws[n+1:]), "\r")
+}
+
+// endTagIndex returns the index of the end tag end, such as "</head>", in
+// text starting in st, or -1. The tag may be split by an action, as in
+// "</head{{if .X}} {{end}}>", so text that ends after the tag name, or after
+// the tag name and whitespace, counts as ending in the tag. "</header>" does
+// not match.
+func endTagIndex(text []byte, end string, st htmlState) int {
+	name := []byte(strings.TrimSuffix(end, ">"))
+	for off := 0; ; {
+		i := st.index(text[off:], name)
+		if i < 0 {
+			return -1
+		}
+		i += off
+		rest := bytes.TrimLeft(text[i+len(name):], " \t\r\n\f")
+		if len(rest) == 0 || rest[0] == '>' {
+			return i
+		}
+		// As in headOpenEnd, the rest starts outside comments and raw text.
+		off, st = i+len(name), htmlState{}
+	}
+}
+
+// headOpenEnd returns the index just past the first <head> or <head ...>
+// start tag in text, starting in st, or -1. <header> does not match.
+func headOpenEnd(text []byte, st htmlState) int {
+	for off := 0; ; {
+		i := st.index(text[off:], []byte("<head"))
+		if i < 0 {
+			return -1
+		}
+		i += off + len("<head")
+		if i < len(text) && strings.IndexByte(">/ \t\r\n\f", text[i]) >= 0 {
+			if end := bytes.IndexByte(text[i:], '>'); end >= 0 {
+				return i + end + 1
+			}
+			return -1
+		}
+		// The match was outside comments and raw text, so the rest starts
+		// there too.
+		off, st = i, htmlState{}
+	}
+}
+
+// htmlState is the context a scan of template text is in: outside markup,
+// or inside an HTML comment (conditional comments included) or a <script> or
+// <style> element, where a literal "</head>" is not an end tag. html/template
+// strips comments, and comments in style and script text, from the output
+// along with anything spliced into them.
+type htmlState struct {
+	closer string // what ends the current comment or element, or ""
+}
+
+// rawOpeners are the constructs whose content is not markup.
+var rawOpeners = []struct{ open, closer string }{
+	{"<!--", "-->"},
+	{"<script", "</script"},
+	{"<style", "</style"},
+}
+
+// index is like bytes.Index but ignores matches of sep inside comments and
+// <script> and <style> elements, for text starting in st. Anything left
+// unterminated runs to the end of text.
+func (st htmlState) index(text, sep []byte) int {
+	for off := 0; ; {
+		if st.closer != "" {
+			end := indexFold(text[off:], st.closer)
+			if end < 0 {
+				return -1
+			}
+			off += end + len(st.closer)
+			st.closer = ""
+		}
+		i := bytes.Index(text[off:], sep)
+		if i < 0 {
+			return -1
+		}
+		o, n, closer := nextRawOpen(text[off : off+i])
+		if o < 0 {
+			return off + i
+		}
+		off += o + n
+		st.closer = closer
+	}
+}
+
+// advance returns the state at the end of text, starting in st.
+func (st htmlState) advance(text []byte) htmlState {
+	for off := 0; ; {
+		if st.closer != "" {
+			end := indexFold(text[off:], st.closer)
+			if end < 0 {
+				return st
+			}
+			off += end + len(st.closer)
+			st.closer = ""
+		}
+		o, n, closer := nextRawOpen(text[off:])
+		if o < 0 {
+			return st
+		}
+		off += o + n
+		st.closer = closer
+	}
+}
+
+// nextRawOpen returns the index and length of the first comment or <script>
+// or <style> start in text, and what closes it, or -1. The opener must be
+// complete within text; "<scripts" and "<stylesheet" do not match.
+func nextRawOpen(text []byte) (int, int, string) {
+	for off := 0; ; {
+		i := bytes.IndexByte(text[off:], '<')
+		if i < 0 {
+			return -1, 0, ""
+		}
+		i += off
+		for _, r := range rawOpeners {
+			if !hasPrefixFold(text[i:], r.open) {
+				continue
+			}
+			j := i + len(r.open)
+			if r.open[1] == '!' || j < len(text) && strings.IndexByte(">/ \t\r\n\f", text[j]) >= 0 {
+				return i, len(r.open), r.closer
+			}
+		}
+		off = i + 1
+	}
+}
+
+// hasPrefixFold is like bytes.HasPrefix but ignores ASCII case.
+func hasPrefixFold(text []byte, prefix string) bool {
+	return len(text) >= len(prefix) && bytes.EqualFold(text[:len(prefix)], []byte(prefix))
+}
+
+// indexFold is like bytes.Index but ignores ASCII case.
+func indexFold(text []byte, sep string) int {
+	for i := 0; i+len(sep) <= len(text); i++ {
+		if hasPrefixFold(text[i:], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// atMarker returns a splicer that replaces marker with tags, one per line,
+// each indented like the marker.
+func atMarker(marker string) splicer {
+	return func(text []byte, tags []string, _ htmlState) ([]byte, bool) {
+		i := bytes.Index(text, []byte(marker))
+		if i < 0 {
+			return nil, false
+		}
+		lineStart := bytes.LastIndexByte(text[:i], '\n') + 1
+		indent := bytes.TrimPrefix(text[lineStart:i], bom)
+		if len(bytes.TrimLeft(indent, " \t")) != 0 {
+			indent = nil
+		}
+		sep := lineEnding(text) + string(indent)
+		return splice(text, i, i+len(marker), []byte(strings.Join(tags, sep))), true
+	}
+}
+
+// splice returns text with text[i:j] replaced by insert. It does not modify
+// text's backing array.
+func splice(text []byte, i, j int, insert []byte) []byte {
+	out := make([]byte, 0, len(text)-(j-i)+len(insert))
+	out = append(out, text[:i]...)
+	out = append(out, insert...)
+	return append(out, text[j:]...)
+}
@@ -0,0 +1,495 @@
+package templatestatic
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestInjectPositions(t *testing.T) {
+	const defs = `{{define "static-css-main"}}a{{end}}{{define "static-js-app"}}b{{end}}`
+	const css = `<link rel="stylesheet" href="/static/main.css">`
+	const js = `<script src="/static/app.js"></script>`
+
+	tests := []struct {
+		name string
+		page string
+		opts []Option
+		want string
+	}{
+		{
+			name: "before head close",
+			page: "<html>\n<head>\n<title>T</title>\n</head>\n</html>",
+			want: "<html>\n<head>\n<title>T</title>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "after head open",
+			page: "<html>\n<head>\n<title>T</title>\n</head>\n</html>",
+			opts: []Option{WithInjectAfterHeadOpen()},
+			want: "<html>\n<head>\n  " + css + "\n  " + js + "\n<title>T</title>\n</head>\n</html>",
+		},
+		{
+			name: "after head open with attributes",
+			page: `<html><header></header><head lang="en"><title>T</title></head></html>`,
+			opts: []Option{WithInjectAfterHeadOpen()},
+			want: `<html><header></header><head lang="en">` + "\n  " + css + "\n  " + js + "\n<title>T</title></head></html>",
+		},
+		{
+			name: "at marker",
+			page: "<html>\n<head>\n  <title>T</title>\n  <!-- static -->\n</head>\n</html>",
+			opts: []Option{WithInjectMarker("<!-- static -->")},
+			want: "<html>\n<head>\n  <title>T</title>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "tidy before head close",
+			page: "<html>\n  <head>\n    <title>T</title>\n\n\n  </head>\n</html>",
+			opts: []Option{WithTidyInjection()},
+			want: "<html>\n  <head>\n    <title>T</title>\n    " + css + "\n    " + js + "\n  </head>\n</html>",
+		},
+		{
+			name: "tidy inline head close",
+			page: "<html><head><title>T</title>   </head></html>",
+			opts: []Option{WithTidyInjection()},
+			want: "<html><head><title>T</title>\n  " + css + "\n  " + js + "\n</head></html>",
+		},
+		{
+			name: "tidy after head open",
+			page: "<html>\n<head>\n\n\n  <title>T</title>\n</head>\n</html>",
+			opts: []Option{WithInjectAfterHeadOpen(), WithTidyInjection()},
+			want: "<html>\n<head>\n  " + css + "\n  " + js + "\n  <title>T</title>\n</head>\n</html>",
+		},
+		{
+			name: "tidy body end",
+			page: "<html><head></head>\n<body>\n  <p>x</p>\n\n</body>\n</html>",
+			opts: []Option{WithTidyInjection(), WithPlacement("static-js-app", AtBodyEnd)},
+			want: "<html><head>\n  " + css + "\n</head>\n<body>\n  <p>x</p>\n  " + js + "\n</body>\n</html>",
+		},
+		{
+			name: "skips head close in comment",
+			page: "<html>\n<!-- </head> -->\n<head>\n</head>\n</html>",
+			want: "<html>\n\n<head>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "skips head close in CSS comment",
+			page: "<html>\n<head>\n<STYLE media=\"all\">/* </head> */p{}</style>\n</head>\n</html>",
+			want: "<html>\n<head>\n<STYLE media=\"all\"> p{}</style>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "skips head close in script across actions",
+			page: "<html>\n<head>\n<script>{{if true}}{{end}}var s = '</head>';</script>\n</head>\n</html>",
+			want: "<html>\n<head>\n<script>var s = '</head>';</script>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "head close split by an action",
+			page: "<html>\n<head>\n<title>T</title>\n</head{{if false}} {{end}}>\n</html>",
+			want: "<html>\n<head>\n<title>T</title>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "skips header close",
+			page: "<html>\n<head>\n</header>\n</head>\n</html>",
+			want: "<html>\n<head>\n</header>\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "conditional comments in head",
+			page: "<html>\n<head>\n<!--[if IE]><link rel=\"stylesheet\" href=\"ie.css\"></head><![endif]-->\n<!--[if !IE]><!--><meta name=\"x\"><!--<![endif]-->\n</head>\n</html>",
+			want: "<html>\n<head>\n\n<meta name=\"x\">\n  " + css + "\n  " + js + "\n</head>\n</html>",
+		},
+		{
+			name: "script at body end",
+			page: "<html>\n<head>\n</head>\n<body>\n<p>x</p>\n</body>\n</html>",
+			opts: []Option{WithPlacement("static-js-app", AtBodyEnd)},
+			want: "<html>\n<head>\n  " + css + "\n</head>\n<body>\n<p>x</p>\n  " + js + "\n</body>\n</html>",
+		},
+		{
+			name: "CRLF before head close",
+			page: "\ufeff<html>\r\n<head>\r\n<title>T</title>\r\n</head>\r\n</html>",
+			want: "\ufeff<html>\r\n<head>\r\n<title>T</title>\r\n  " + css + "\r\n  " + js + "\r\n</head>\r\n</html>",
+		},
+		{
+			name: "CRLF after head open",
+			page: "<html>\r\n<head>\r\n<title>T</title>\r\n</head>\r\n</html>",
+			opts: []Option{WithInjectAfterHeadOpen()},
+			want: "<html>\r\n<head>\r\n  " + css + "\r\n  " + js + "\r\n<title>T</title>\r\n</head>\r\n</html>",
+		},
+		{
+			name: "CRLF tidy before head close",
+			page: "<html>\r\n  <head>\r\n    <title>T</title>\r\n\r\n  </head>\r\n</html>",
+			opts: []Option{WithTidyInjection()},
+			want: "<html>\r\n  <head>\r\n    <title>T</title>\r\n    " + css + "\r\n    " + js + "\r\n  </head>\r\n</html>",
+		},
+		{
+			name: "CRLF tidy after head open",
+			page: "<html>\r\n  <head>\r\n\r\n    <title>T</title>\r\n  </head>\r\n</html>",
+			opts: []Option{WithInjectAfterHeadOpen(), WithTidyInjection()},
+			want: "<html>\r\n  <head>\r\n    " + css + "\r\n    " + js + "\r\n    <title>T</title>\r\n  </head>\r\n</html>",
+		},
+		{
+			name: "CRLF marker",
+			page: "<html>\r\n<head>\r\n  <!-- static -->\r\n</head>\r\n</html>",
+			opts: []Option{WithInjectMarker("<!-- static -->")},
+			want: "<html>\r\n<head>\r\n  " + css + "\r\n  " + js + "\r\n</head>\r\n</html>",
+		},
+		{
+			name: "BOM before marker",
+			page: "\ufeff    <!-- static --><html></html>",
+			opts: []Option{WithInjectMarker("<!-- static -->")},
+			want: "\ufeff    " + css + "\n    " + js + "<html></html>",
+		},
+		{
+			name: "body end with head marker",
+			page: "<html><head><!-- static --></head><body></body></html>",
+			opts: []Option{WithInjectMarker("<!-- static -->"), WithPlacement("static-js-app", AtBodyEnd)},
+			want: "<html><head>" + css + "</head><body>\n  " + js + "\n</body></html>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("page").Parse(defs + tt.page))
+			rt, err := Parse(tmpl, nil, t.TempDir(), "/static", tt.opts...)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := rt.Execute(&buf, nil); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithoutInjection(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateExplicit))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, nil, outDir, "/static", WithoutInjection())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, name := range []string{"critical.css", "app.js"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+
+	// Every template renders exactly as in the original.
+	orig := template.Must(tmpl.Clone())
+	for _, tt := range orig.Templates() {
+		var want, got bytes.Buffer
+		if err := orig.ExecuteTemplate(&want, tt.Name(), nil); err != nil {
+			t.Fatalf("original %s: %v", tt.Name(), err)
+		}
+		if err := rt.ExecuteTemplate(&got, tt.Name(), nil); err != nil {
+			t.Fatalf("result %s: %v", tt.Name(), err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s rendered %q, want %q", tt.Name(), got.String(), want.String())
+		}
+	}
+	if len(rt.Templates()) != len(orig.Templates()) {
+		t.Errorf("result has %d templates, want %d", len(rt.Templates()), len(orig.Templates()))
+	}
+}
+
+func TestWithOrder(t *testing.T) {
+	const tmplStr = `{{define "static-css-a"}}a{{end}}
+{{define "static-css-b"}}b{{end}}
+{{define "static-js-loader"}}l{{end}}
+{{define "static-js-z"}}z{{end}}
+{{define "page"}}<head></head>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+
+	tests := []struct {
+		opts []Option
+		want []string
+	}{
+		{nil, []string{"a.css", "b.css", "loader.js", "z.js"}},
+		{[]Option{WithOrder("static-js-loader", "static-css-b")}, []string{"loader.js", "b.css", "a.css", "z.js"}},
+	}
+	for _, tt := range tests {
+		rt, err := Parse(tmpl, nil, t.TempDir(), "/s", tt.opts...)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		if got := tagOrder(buf.String()); !slices.Equal(got, tt.want) {
+			t.Errorf("order = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestWithTagOrder(t *testing.T) {
+	const tmplStr = `{{define "static-js-z"}}z{{end}}
+{{define "static-css-b"}}b{{end}}
+{{define "static-js-loader"}}l{{end}}
+{{define "static-css-a"}}a{{end}}
+{{define "page"}}<head></head>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+
+	tests := []struct {
+		opts []Option
+		want []string
+	}{
+		{nil, []string{"a.css", "b.css", "loader.js", "z.js"}},
+		{[]Option{WithTagOrder(CSSFirst)}, []string{"a.css", "b.css", "loader.js", "z.js"}},
+		{[]Option{WithTagOrder(JSFirst)}, []string{"loader.js", "z.js", "a.css", "b.css"}},
+		{[]Option{WithTagOrder(SourceOrder)}, []string{"z.js", "b.css", "loader.js", "a.css"}},
+		{[]Option{WithTagOrder(SourceOrder), WithOrder("static-css-a")}, []string{"a.css", "z.js", "b.css", "loader.js"}},
+	}
+	for _, tt := range tests {
+		rt, err := Parse(tmpl, nil, t.TempDir(), "/s", tt.opts...)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		if got := tagOrder(buf.String()); !slices.Equal(got, tt.want) {
+			t.Errorf("order = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+// Across files, SourceOrder goes by file name, then position.
+func TestWithTagOrderSourceAcrossFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b.html": {Data: []byte(`{{define "static-css-y"}}y{{end}}{{define "static-js-x"}}x{{end}}`)},
+		"a.html": {Data: []byte(`{{define "static-js-w"}}w{{end}}{{define "page"}}<head></head>{{end}}`)},
+	}
+	tmpl := template.Must(template.ParseFS(fsys, "*.html"))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/s", WithTagOrder(SourceOrder))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if got, want := tagOrder(buf.String()), []string{"w.js", "y.css", "x.js"}; !slices.Equal(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestBuildInjections(t *testing.T) {
+	const defs = `{{define "static-css-main"}}a{{end}}{{define "static-js-app"}}b{{end}}`
+	tests := []struct {
+		name string
+		page string
+		opts []Option
+		want int
+	}{
+		{"head", `<head></head><body></body>`, nil, 1},
+		{"head and body", `<head></head><body></body>`, []Option{WithPlacement("static-js-app", AtBodyEnd)}, 2},
+		{"no head", `<p>fragment</p>`, nil, 0},
+		{"all placed", `<head>{{template "static-css-main"}}{{template "static-js-app"}}</head>`, nil, 0},
+		{"without injection", `<head></head>`, []Option{WithoutInjection()}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("page").Parse(defs + tt.page))
+			res, err := Build(tmpl, nil, t.TempDir(), "/static", tt.opts...)
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			if res.Injections != tt.want {
+				t.Errorf("Injections = %d, want %d", res.Injections, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithStrictInjection(t *testing.T) {
+	const defs = `{{define "static-css-main"}}a{{end}}{{define "static-js-app"}}b{{end}}`
+	for page, wantErr := range map[string]bool{
+		`<head></head>`:   false,
+		`<p>fragment</p>`: true,
+		`<head>{{template "static-css-main"}}{{template "static-js-app"}}</head>`: false,
+	} {
+		tmpl := template.Must(template.New("page").Parse(defs + page))
+		_, err := Build(tmpl, nil, t.TempDir(), "/static", WithStrictInjection())
+		if (err != nil) != wantErr {
+			t.Errorf("%s: err = %v, want error %v", page, err, wantErr)
+		}
+	}
+	tmpl := template.Must(template.New("page").Parse(defs + `<head></head>`))
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithStrictInjection(), WithPlacement("static-js-app", AtBodyEnd)); err == nil {
+		t.Error("expected error for a missing </body>")
+	}
+}
+
+func TestWithInjectInto(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{{end}}
+{{define "a-email"}}<html><head></head></html>{{end}}
+{{define "base"}}<html><head></head><body>{{block "content" .}}{{end}}</body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithInjectInto("base"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for name, want := range map[string]bool{"a-email": false, "base": true} {
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, name, nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		if got := strings.Contains(buf.String(), "<link"); got != want {
+			t.Errorf("%s: has tags = %v, want %v\n%s", name, got, want, buf.String())
+		}
+	}
+
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithInjectInto("layout")); err == nil {
+		t.Error("expected error for a missing template")
+	}
+}
+
+func TestInjectReachableFirst(t *testing.T) {
+	// The page's </head> is in an included template that sorts after an
+	// unrelated document with its own head.
+	const tmplStr = `{{template "head" .}}<body>Hi</body></html>
+{{define "static-css-main"}}a{{end}}
+{{define "a-email"}}<html><head></head></html>{{end}}
+{{define "head"}}<html><head><title>T</title>{{template "z-head-end"}}{{end}}
+{{define "z-head-end"}}</head>{{end}}`
+	tmpl := template.Must(template.New("page").Parse(tmplStr))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for name, want := range map[string]bool{"a-email": false, "page": true} {
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, name, nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		if got := strings.Contains(buf.String(), "<link"); got != want {
+			t.Errorf("%s: has tags = %v, want %v\n%s", name, got, want, buf.String())
+		}
+	}
+}
+
+func TestWithBuildComment(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithBuildComment("assets generated 2024-05-01"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := "<title>Test</title>\n" +
+		"  <!-- assets generated 2024-05-01 -->\n" +
+		"  <link rel=\"stylesheet\" href=\"/static/main.css\">\n" +
+		"  <script src=\"/static/app.js\"></script>\n" +
+		"</head>"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("output missing\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithBuildComment("a -- b")); err == nil {
+		t.Error(`expected error for a comment containing "--"`)
+	}
+}
+
+func TestWithHeadTags(t *testing.T) {
+	const charset = `<meta charset="utf-8">`
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithHeadTags(charset), WithHeadTags(`<meta name="x" content="y">`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := "<title>Test</title>\n" +
+		"  " + charset + "\n" +
+		"  <meta name=\"x\" content=\"y\">\n" +
+		"  <link rel=\"stylesheet\" href=\"/static/main.css\">\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("output missing\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	// A page that already has the tag does not get a second one.
+	has := template.Must(template.New("test").Parse(strings.Replace(testTemplateAuto, "<head>", `<head><META charset="UTF-8">`, 1)))
+	rt, err = Parse(has, nil, t.TempDir(), "/static", WithHeadTags(charset))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	buf.Reset()
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if n := strings.Count(strings.ToLower(buf.String()), "charset"); n != 1 {
+		t.Errorf("got %d charset tags\n%s", n, buf.String())
+	}
+}
+
+func TestInjectTags(t *testing.T) {
+	tags := []string{`<link rel="icon" href="/favicon.ico">`, `<meta name="x" content="y">`}
+	tests := []struct {
+		name  string
+		page  string
+		opts  []Option
+		want  string
+		found bool
+	}{
+		{
+			name:  "before head close",
+			page:  "<head>\n<title>T</title>\n</head>",
+			want:  "<head>\n<title>T</title>\n  " + tags[0] + "\n  " + tags[1] + "\n</head>",
+			found: true,
+		},
+		{
+			name:  "at marker",
+			page:  "<head>\n  <!-- here -->\n</head>",
+			opts:  []Option{WithInjectMarker("<!-- here -->"), WithFingerprint()},
+			want:  "<head>\n  " + tags[0] + "\n  " + tags[1] + "\n</head>",
+			found: true,
+		},
+		{
+			name:  "inside if",
+			page:  "{{if .}}<head></head>{{end}}",
+			want:  "<head>\n  " + tags[0] + "\n  " + tags[1] + "\n</head>",
+			found: true,
+		},
+		{
+			name: "no injection point",
+			page: "<p>fragment</p>",
+			want: "<p>fragment</p>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("page").Parse(tt.page))
+			if found := InjectTags(tmpl, tags, tt.opts...); found != tt.found {
+				t.Errorf("InjectTags = %v, want %v", found, tt.found)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, true); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// tagOrder returns the file names referenced by tags in out, in order.
+func tagOrder(out string) []string {
+	var files []string
+	for _, m := range regexp.MustCompile(`(?:href|src)="/s/([^"]+)"`).FindAllStringSubmatch(out, -1) {
+		files = append(files, m[1])
+	}
+	return files
+}
@@ -0,0 +1,179 @@
+package templatestatic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestEntry is the JSON form of an asset in the manifest file.
+type manifestEntry struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	File string `json:"file"`
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+
+	// Sizes are the sizes of the file, as "identity", and of its compressed
+	// siblings by content coding; only with WithCompression.
+	Sizes map[string]int64 `json:"sizes,omitempty"`
+
+	// Owner is the WithMergeManifest owner that wrote the entry, if any.
+	Owner string `json:"owner,omitempty"`
+}
+
+func manifestPath(outputDir, filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(outputDir, filename)
+}
+
+// writeManifest writes assets to path as a JSON object keyed by logical name,
+// leaving out inline assets. Keys are sorted by encoding/json, so the output
+// is deterministic.
+//
+// If owner is not empty, the entries are merged into the manifest already at
+// path, if any, instead: entries of owner are replaced by assets, those of
+// other owners are kept, and a logical name another owner has is an error.
+func writeManifest(path string, assets []AssetInfo, owner string) error {
+	m := make(map[string]manifestEntry, len(assets))
+	if owner != "" {
+		var err error
+		if m, err = otherEntries(path, owner); err != nil {
+			return err
+		}
+	}
+	for _, a := range assets {
+		if a.File == "" {
+			continue // inline, nothing to reference
+		}
+		if prev, ok := m[a.Logical]; ok {
+			return fmt.Errorf("%q of %q conflicts with %q of %q", a.Logical, owner, prev.Name, prev.Owner)
+		}
+		e := manifestEntry{Name: a.Name, Kind: a.Kind, File: a.File, URL: a.URL, Hash: a.Hash, Owner: owner}
+		if len(a.CompressedSizes) > 0 {
+			e.Sizes = maps.Clone(a.CompressedSizes)
+			e.Sizes["identity"] = a.Size
+		}
+		m[a.Logical] = e
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = writeIfChanged(path, append(data, '\n'))
+	return err
+}
+
+// otherEntries returns the entries of the manifest at path not written by
+// owner, or none if there is no manifest.
+func otherEntries(path, owner string) (map[string]manifestEntry, error) {
+	m := make(map[string]manifestEntry)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	maps.DeleteFunc(m, func(_ string, e manifestEntry) bool { return e.Owner == owner })
+	return m, nil
+}
+
+// checkMergeConflicts returns an error if a static has a logical name that
+// another owner has in the manifest at path, before any file is written over
+// that owner's.
+func checkMergeConflicts(path, owner string, statics []staticDef) error {
+	others, err := otherEntries(path, owner)
+	if err != nil {
+		return fmt.Errorf("templatestatic: %w", err)
+	}
+	for _, s := range statics {
+		if prev, ok := others[s.logical]; ok && !s.inline {
+			return fmt.Errorf("templatestatic: %q of %q conflicts with %q of %q in manifest %s", s.logical, owner, prev.Name, prev.Owner, path)
+		}
+	}
+	return nil
+}
+
+// writeChecksums writes the SHA-256 of every file statics are written to,
+// source maps included, to path as sha256sum output.
+func writeChecksums(path string, statics []staticDef) error {
+	base := filepath.Dir(path)
+	var lines []string
+	add := func(dir, file, hash string) {
+		full := filepath.Join(dir, filepath.FromSlash(file))
+		name := full
+		if rel, err := filepath.Rel(base, full); err == nil && filepath.IsLocal(rel) {
+			name = rel
+		}
+		lines = append(lines, hash+"  "+filepath.ToSlash(name)+"\n")
+	}
+	for _, s := range statics {
+		if s.inline || s.shared {
+			continue
+		}
+		add(s.dir, s.filename, s.hash)
+		if s.sourceMap != nil {
+			sum := sha256.Sum256(s.sourceMap)
+			add(s.dir, s.mapFile(), hex.EncodeToString(sum[:]))
+		}
+	}
+	// Sort by path, which follows the fixed-width hash on each line.
+	sort.Slice(lines, func(i, j int) bool { return lines[i][64:] < lines[j][64:] })
+	_, err := writeIfChanged(path, []byte(strings.Join(lines, "")))
+	return err
+}
+
+// verifyUnmodified returns an error if a file that statics would overwrite
+// differs from the hash recorded for it in the manifest at path, meaning it
+// was edited since this package wrote it. Files the manifest does not list,
+// missing files, and a missing manifest are not errors.
+func verifyUnmodified(path string, statics []staticDef) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m map[string]manifestEntry
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("templatestatic: manifest %s: %w", path, err)
+	}
+	recorded := make(map[string]string, len(m))
+	for _, e := range m {
+		recorded[e.File] = e.Hash
+	}
+
+	for _, s := range statics {
+		hash, ok := recorded[s.filename]
+		if s.inline || !ok {
+			continue
+		}
+		file := filepath.Join(s.dir, filepath.FromSlash(s.filename))
+		existing, err := fileHash(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("templatestatic: %q: %w", s.name, err)
+		}
+		if existing != hash && existing != s.hash {
+			return fmt.Errorf("templatestatic: %s was modified since it was generated; move the change into %q or delete the file", file, s.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package templatestatic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithManifest(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+
+	res, err := Build(tmpl, nil, outDir, "/static", WithFingerprint(), WithManifest("assets.json"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	path := filepath.Join(outDir, "assets.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading manifest: %v", err)
+	}
+	var m map[string]manifestEntry
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("manifest is not valid JSON: %v\n%s", err, data)
+	}
+	if len(m) != len(res.Assets) {
+		t.Errorf("manifest has %d entries, want %d", len(m), len(res.Assets))
+	}
+	for _, a := range res.Assets {
+		e, ok := m[a.Logical]
+		if !ok {
+			t.Errorf("manifest missing %s", a.Logical)
+			continue
+		}
+		want := manifestEntry{Name: a.Name, Kind: a.Kind, File: a.File, URL: a.URL, Hash: a.Hash}
+		if !reflect.DeepEqual(e, want) {
+			t.Errorf("manifest[%s] = %+v, want %+v", a.Logical, e, want)
+		}
+	}
+
+	// An unchanged rebuild leaves the manifest untouched.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(tmpl, nil, outDir, "/static", WithFingerprint(), WithManifest("assets.json")); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Error("manifest rewritten on unchanged rebuild")
+	}
+}
+
+func TestWithVerify(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	opts := []Option{WithManifest("manifest.json"), WithVerify()}
+	if _, err := Build(tmpl, nil, outDir, "/static", opts...); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	// Unchanged files, and a changed template, rebuild fine.
+	if _, err := Build(tmpl, nil, outDir, "/static", opts...); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	changed := template.Must(template.New("test").Parse(strings.Replace(testTemplateAuto, "red", "blue", 1)))
+	if _, err := Build(changed, nil, outDir, "/static", opts...); err != nil {
+		t.Fatalf("rebuild after template change: %v", err)
+	}
+
+	// A hotfix made directly to the output is detected and kept.
+	path := filepath.Join(outDir, "main.css")
+	if err := os.WriteFile(path, []byte("body { color: green; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Build(tmpl, nil, outDir, "/static", opts...)
+	if err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Fatalf("Build error = %v, want external modification detected", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "body { color: green; }" {
+		t.Errorf("hotfix overwritten: %q", got)
+	}
+	// Without WithVerify it is overwritten as before.
+	if _, err := Build(tmpl, nil, outDir, "/static", WithManifest("manifest.json")); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithVerify()); err == nil {
+		t.Error("expected error for WithVerify without WithManifest")
+	}
+}
+
+func TestWithChecksums(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	jsDir := filepath.Join(t.TempDir(), "js")
+	res, err := Build(tmpl, nil, outDir, "/static", WithFingerprint(), WithMinifier(stripSpaces), WithSourceMaps(),
+		WithDestination(KindJS, jsDir, "/js"), WithChecksums("SHA256SUMS"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := regexp.MustCompile(`^([0-9a-f]{64})  (\S+)$`)
+	var names []string
+	for _, l := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		m := line.FindStringSubmatch(l)
+		if m == nil {
+			t.Fatalf("malformed line %q in\n%s", l, data)
+		}
+		file := m[2]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(outDir, file)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != m[1] {
+			t.Errorf("%s: checksum does not match the file", m[2])
+		}
+		names = append(names, m[2])
+	}
+	css := res.Assets[0]
+	want := []string{filepath.ToSlash(filepath.Join(jsDir, res.Assets[1].File)), filepath.ToSlash(filepath.Join(jsDir, "app.js.map")), css.File, "main.css.map"}
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithChecksums("main.css")); err == nil {
+		t.Error("expected error for a checksums file overwriting an asset")
+	}
+	if _, err := Build(tmpl, nil, filepath.Join(t.TempDir(), "static"), "/static", WithChecksums("../SUMS")); err == nil {
+		t.Error("expected error for a relative checksums file outside outputDir")
+	}
+}
+
+func TestWithMergeManifest(t *testing.T) {
+	site := template.Must(template.New("test").Parse(testTemplateAuto))
+	admin := template.Must(template.New("test").Parse(`{{define "static-css-admin"}}a{}{{end}}`))
+	outDir := t.TempDir()
+	build := func(tmpl *template.Template, owner string) error {
+		_, err := Build(tmpl, nil, outDir, "/static", WithManifest("manifest.json"), WithMergeManifest(owner))
+		return err
+	}
+	read := func() map[string]manifestEntry {
+		data, err := os.ReadFile(filepath.Join(outDir, "manifest.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]manifestEntry
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	if err := build(site, "site"); err != nil {
+		t.Fatalf("site: %v", err)
+	}
+	if err := build(admin, "admin"); err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+	// Rebuilding one set replaces only its own entries.
+	if err := build(template.Must(template.New("test").Parse(`{{define "static-css-main"}}b{}{{end}}`)), "site"); err != nil {
+		t.Fatalf("site again: %v", err)
+	}
+	m := read()
+	owners := make(map[string]string)
+	for logical, e := range m {
+		owners[logical] = e.Owner
+	}
+	if want := map[string]string{"main.css": "site", "admin.css": "admin"}; !maps.Equal(owners, want) {
+		t.Errorf("manifest owners = %v, want %v", owners, want)
+	}
+
+	// Another set with a taken logical name fails before writing over it.
+	clash := template.Must(template.New("test").Parse(`{{define "static-css-admin"}}x{}{{end}}`))
+	err := build(clash, "site")
+	if err == nil || !strings.Contains(err.Error(), `"admin.css" of "site" conflicts with "static-css-admin" of "admin"`) {
+		t.Errorf("err = %v, want a conflict on admin.css", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outDir, "admin.css")); string(data) != "a{}" {
+		t.Errorf("admin.css = %q after a conflicting build", data)
+	}
+
+	if _, err := Build(site, nil, t.TempDir(), "/static", WithMergeManifest("site")); err == nil {
+		t.Error("expected error without WithManifest")
+	}
+}
@@ -0,0 +1,131 @@
+package templatestatic
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// multiFileSet mimics a ParseGlob-style project: a layout, a page, and static
+// definitions spread across their own files.
+var multiFileSet = fstest.MapFS{
+	"layout.html": {Data: []byte(`{{define "layout"}}<html>
+<head>
+<title>{{block "title" .}}Site{{end}}</title>
+{{template "static-css-critical"}}
+</head>
+<body>{{template "content" .}}</body>
+</html>{{end}}`)},
+	"page.html":    {Data: []byte(`{{template "layout" .}}{{define "title"}}Page{{end}}{{define "content"}}Hello{{end}}`)},
+	"styles.html":  {Data: []byte(`{{define "static-css-critical"}}h1{}{{end}}{{define "static-css-main"}}body{}{{end}}`)},
+	"scripts.html": {Data: []byte(`{{define "static-js-app"}}go(){{end}}`)},
+}
+
+func TestParseMultiFileSet(t *testing.T) {
+	tmpl := template.Must(template.ParseFS(multiFileSet, "*.html"))
+
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page.html", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := `<html>
+<head>
+<title>Page</title>
+<link rel="stylesheet" href="/static/critical.css">
+  <link rel="stylesheet" href="/static/main.css">
+  <script src="/static/app.js"></script>
+</head>
+<body>Hello</body>
+</html>`
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The source set still renders without any tags.
+	buf.Reset()
+	if err := tmpl.ExecuteTemplate(&buf, "page.html", nil); err != nil {
+		t.Fatalf("original ExecuteTemplate: %v", err)
+	}
+	if strings.Contains(buf.String(), "<link") || strings.Contains(buf.String(), "<script") {
+		t.Errorf("original modified:\n%s", buf.String())
+	}
+}
+
+// With several documents containing </head>, the tags go into the first by
+// template name, every time.
+func TestParseMultiFileInjectionDeterministic(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b-main.html":  {Data: []byte(`<html><head></head></html>`)},
+		"a-email.html": {Data: []byte(`<html><head></head></html>`)},
+		"styles.html":  {Data: []byte(`{{define "static-css-x"}}x{}{{end}}`)},
+	}
+	for i := 0; i < 20; i++ {
+		tmpl := template.Must(template.ParseFS(fsys, "*.html"))
+		rt, err := Parse(tmpl, nil, t.TempDir(), "/static")
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var a, b bytes.Buffer
+		if err := rt.ExecuteTemplate(&a, "a-email.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.ExecuteTemplate(&b, "b-main.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(a.String(), "<link") || strings.Contains(b.String(), "<link") {
+			t.Fatalf("run %d: tags not injected into a-email.html only:\na: %s\nb: %s", i, a.String(), b.String())
+		}
+	}
+}
+
+// A real ParseGlob set on disk: statics in their own file, </head> in a
+// layout's define, and an earlier-named partial that only mentions </head> in
+// an HTML comment, which must not attract the injection.
+func TestParseGlobInjectsIntoLayout(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a-notes.html": `{{define "areas"}}<!-- the layout closes </head> -->{{end}}`,
+		"layout.html": `{{define "layout"}}<html>
+<head>
+<title>T</title>
+</head>
+<body>{{template "areas"}}{{template "content" .}}</body>
+</html>{{end}}`,
+		"index.html":   `{{template "layout" .}}{{define "content"}}Hi{{end}}`,
+		"statics.html": `{{define "static-css-main"}}body{}{{end}}{{define "static-js-app"}}go(){{end}}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpl := template.Must(template.ParseGlob(filepath.Join(dir, "*.html")))
+
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "index.html", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := `<html>
+<head>
+<title>T</title>
+  <link rel="stylesheet" href="/static/main.css">
+  <script src="/static/app.js"></script>
+</head>
+<body>Hi</body>
+</html>`
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
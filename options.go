@@ -0,0 +1,861 @@
+package templatestatic
+
+import (
+	"crypto"
+	"html/template"
+	"io"
+	"io/fs"
+	"slices"
+	texttemplate "text/template"
+	"time"
+)
+
+// An Option configures Parse and Build.
+type Option func(*config)
+
+type config struct {
+	nearMisses       bool
+	strictNearMisses bool
+	allowedRoot      string
+	fingerprint      bool
+	fingerprintLen   int
+	fingerprintFunc  func([]byte) string
+	integrityHash    crypto.Hash
+	integrityMode    IntegrityMode
+	streamWrites     bool
+	compressors      []compressor
+	bannerText       string
+	bannerTmpl       *texttemplate.Template
+	buildTime        time.Time
+	embedded         fs.FS
+	buildComment     string
+	headTags         []string
+	manifestOwner    string
+	rawHTML          []string // tags emitted through rawFn
+	validators       []Validator
+	allErrors        bool
+	manifest         string
+	checksums        string
+	goConstFile      string
+	goConstPkg       string
+	defs             map[string]*defConfig
+	afterHeadOpen    bool
+	marker           string
+	injectInto       []string
+	relativeURLs     bool
+	noURLExt         bool
+	noFileExt        bool
+	exts             map[Kind]string
+	noInject         bool
+	extractOnly      bool // set by Extract: build no result template
+	forcedKinds      map[string]Kind
+	urlFunc          func(logical, filename string) string
+	inlineAll        bool
+	onWritten        func(AssetInfo)
+	prefixTemplates  bool
+	inPlace          bool
+	leftDelim        string
+	rightDelim       string
+	onSkipped        func(AssetInfo)
+	minifier         Minifier
+	sourceMaps       bool
+	trailingNewline  bool
+	order            []string
+	placeOnce        bool
+	xhtml            bool
+	tagTexts         map[Kind]string
+	tagTmpls         map[Kind]*template.Template // parsed from tagTexts by Build
+	tagOrder         TagOrder
+	filenameCase     FilenameCase
+	bundles          []bundle
+	keepSources      bool
+	watchInterval    time.Duration
+	stripDefs        bool
+	stripFuncs       template.FuncMap
+	transforms       []Transform
+	dests            map[Kind]destination
+	verify           bool
+	subdirs          map[Kind]string
+	tidy             bool
+	dedupe           bool
+	preview          io.Writer
+	strictInject     bool
+}
+
+// destination is where assets of one kind are written and served from.
+type destination struct {
+	dir, urlPrefix string
+}
+
+// streams reports whether the named static's content is streamed to its
+// file under WithStreamingWrites: only if nothing needs it in memory.
+func (c *config) streams(name string) bool {
+	return c.streamWrites && len(c.transforms) == 0 && c.minifier == nil && !c.trailingNewline &&
+		c.fingerprintFunc == nil && c.preview == nil && len(c.compressors) == 0 && c.bannerText == "" && c.embedded == nil && len(c.validators) == 0 && !c.def(name).inline
+}
+
+// fingerprintOf returns the fingerprint of content, whose hex SHA-256 is hash.
+func (c *config) fingerprintOf(content []byte, hash string) string {
+	n := c.fingerprintLen
+	if c.fingerprintFunc != nil {
+		fp := c.fingerprintFunc(content)
+		if n == 0 || len(fp) <= n {
+			return fp
+		}
+		return fp[:n]
+	}
+	if n == 0 {
+		n = 8
+	}
+	return hash[:n]
+}
+
+// extension returns the file extension to use for k.
+func (c *config) extension(k kindInfo) string {
+	if ext, ok := c.exts[k.kind]; ok {
+		return ext
+	}
+	return k.ext
+}
+
+// defConfig holds options that apply to a single static definition.
+type defConfig struct {
+	linkAttrs map[string]string
+	placement Placement
+	filename  string
+	inline    bool
+	asyncCSS  bool
+	condition string
+}
+
+// def returns the options for the named static definition, creating them if
+// needed.
+func (c *config) def(name string) *defConfig {
+	if c.defs == nil {
+		c.defs = make(map[string]*defConfig)
+	}
+	d := c.defs[name]
+	if d == nil {
+		d = &defConfig{}
+		c.defs[name] = d
+	}
+	return d
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithNearMissDetection reports template names that look like misspelled
+// static definitions, such as "static-cs-main", in Result.NearMisses.
+func WithNearMissDetection() Option {
+	return func(c *config) { c.nearMisses = true }
+}
+
+// WithStrictNearMisses is like WithNearMissDetection but makes Build return an
+// error, before writing any files, if a near miss is found.
+func WithStrictNearMisses() Option {
+	return func(c *config) {
+		c.nearMisses = true
+		c.strictNearMisses = true
+	}
+}
+
+// WithAllowedRoot makes Build fail, before writing anything, if outputDir, a
+// WithDestination directory, or the file of WithManifest, WithChecksums, or
+// WithGoConstants does not resolve to root or a path beneath it. Both paths are made absolute
+// and have symlinks resolved before comparison.
+func WithAllowedRoot(root string) Option {
+	return func(c *config) { c.allowedRoot = root }
+}
+
+// WithFingerprint inserts a short content hash into each filename, so
+// "static-css-main" is written as main.1a2b3c4d.css. Fingerprinted files can
+// be cached indefinitely; see FileServer.
+func WithFingerprint() Option {
+	return func(c *config) { c.fingerprint = true }
+}
+
+// WithFingerprintHash replaces the fingerprint WithFingerprint inserts, by
+// default the first 8 hex digits of the SHA-256 of the content, with
+// fn(content), e.g. a truncated xxhash. fn must return only letters, digits,
+// '-', and '_'; FileServer treats files as fingerprinted if the result is at
+// least 8 lowercase hex digits. Hash, ETag, and the manifest stay SHA-256.
+func WithFingerprintHash(fn func(content []byte) string) Option {
+	return func(c *config) { c.fingerprintFunc = fn }
+}
+
+// WithFingerprintLength sets how many characters of the hash WithFingerprint
+// inserts: 8 by default, up to 64 for the whole SHA-256. A fingerprint from
+// WithFingerprintHash is also cut to n if longer. Lengths under 8 are an
+// error.
+//
+// The fingerprint is what tells browsers an asset has changed, so two
+// versions of one asset with the same fingerprint serve the cached old one.
+// With n hex digits that happens for a given pair with probability 16^-n: 1
+// in about 4 billion at 8, and 1 in about 280 trillion at 12.
+func WithFingerprintLength(n int) Option {
+	return func(c *config) { c.fingerprintLen = n }
+}
+
+// An IntegrityMode selects which built-in tags carry integrity and
+// crossorigin attributes.
+type IntegrityMode int
+
+const (
+	// IntegrityCrossOrigin adds them to tags whose URL is on another origin,
+	// starting with http://, https://, or //, such as a CDN, and not to
+	// same-origin URLs like /static/main.css, where crossorigin only makes
+	// the browser fetch, and cache, the asset separately.
+	IntegrityCrossOrigin IntegrityMode = iota
+	// IntegrityAlways adds them to every tag.
+	IntegrityAlways
+	// IntegrityNever adds them to no tag.
+	IntegrityNever
+)
+
+// WithIntegrity sets which built-in tags get an integrity attribute, with
+// the value in AssetInfo.Integrity, and crossorigin="anonymous" (or the
+// crossorigin set by WithLinkAttrs). The default is IntegrityCrossOrigin.
+// Tag templates decide for themselves.
+func WithIntegrity(m IntegrityMode) Option {
+	return func(c *config) { c.integrityMode = m }
+}
+
+// WithIntegrityHash sets the algorithm of the subresource integrity value
+// given to tag templates and reported in AssetInfo.Integrity: crypto.SHA256,
+// crypto.SHA384 (the default), or crypto.SHA512, the ones browsers accept.
+func WithIntegrityHash(h crypto.Hash) Option {
+	return func(c *config) { c.integrityHash = h }
+}
+
+// WithDedupe writes statics of the same kind whose content is byte-for-byte
+// identical to a single file, the one of the first by name, and points all
+// of their tags and URLs at it. It requires WithFingerprint, since files are
+// identified by their content hash, and is ignored without it.
+func WithDedupe() Option {
+	return func(c *config) { c.dedupe = true }
+}
+
+// WithAllErrors makes Build keep going when a static definition fails to
+// render, returning every failure joined with errors.Join instead of only the
+// first. Nothing is written if any definition fails.
+func WithAllErrors() Option {
+	return func(c *config) { c.allErrors = true }
+}
+
+// WithManifest writes a JSON manifest to filename, resolved relative to
+// outputDir unless absolute; a relative filename must not leave outputDir. It maps each asset's logical name ("main.css")
+// to its template name, kind, file, URL, and hash, and like the assets it is
+// only rewritten when its content changes.
+func WithManifest(filename string) Option {
+	return func(c *config) { c.manifest = filename }
+}
+
+// WithMergeManifest makes WithManifest merge this build's entries into the
+// manifest already there, for template sets that share one outputDir, rather
+// than replace it. Each entry records owner, which names the template set:
+// entries of owner are replaced, those of others are kept, and a logical
+// name another owner already has is an error. Builds that merge into one
+// manifest must not run concurrently.
+func WithMergeManifest(owner string) Option {
+	return func(c *config) { c.manifestOwner = owner }
+}
+
+// WithChecksums writes a checksums file to filename, resolved like the
+// manifest, in the format of sha256sum: a line "<hex SHA-256>  <path>" for
+// each asset and source map, sorted by path, so the output can be checked
+// with sha256sum -c from the file's directory. Paths are relative to that
+// directory, or absolute for files outside it. Like the assets, it is only
+// rewritten when its content changes.
+func WithChecksums(filename string) Option {
+	return func(c *config) { c.checksums = filename }
+}
+
+// WithGoConstants writes a Go source file to filename, resolved like the
+// manifest, declaring a constant in package pkg for each asset's URL, so
+// handlers can reference fingerprinted URLs at compile time. Constants are
+// named after logical names: main.css is MainCSS and admin/app.js is
+// AdminAppJS. The file is gofmt'd and only rewritten when its content
+// changes.
+func WithGoConstants(filename, pkg string) Option {
+	return func(c *config) { c.goConstFile, c.goConstPkg = filename, pkg }
+}
+
+// WithLinkAttrs adds attributes to the <link> tag generated for the named
+// static-css or static-icon definition, e.g. {"data-turbo-track": "reload"}.
+// Values are HTML-escaped; an empty value emits a bare attribute. A "rel"
+// entry replaces the default "stylesheet" or "icon". Calling it again for the
+// same name adds to the earlier attributes.
+func WithLinkAttrs(name string, attrs map[string]string) Option {
+	return func(c *config) {
+		d := c.def(name)
+		if d.linkAttrs == nil {
+			d.linkAttrs = make(map[string]string)
+		}
+		for k, v := range attrs {
+			d.linkAttrs[k] = v
+		}
+	}
+}
+
+// WithRel sets the rel of the <link> generated for the named static-css
+// definition, wherever its tag is emitted, instead of "stylesheet": e.g.
+// "alternate stylesheet" for an alternate theme, which also wants a title
+// from WithLinkAttrs, or "preload", which gets as="style". It is the same as
+// a "rel" entry in WithLinkAttrs.
+func WithRel(name, rel string) Option {
+	return WithLinkAttrs(name, map[string]string{"rel": rel})
+}
+
+// WithConditionalComment wraps the tag of the named static, placed or
+// auto-injected, in an IE conditional comment with the given condition:
+// "lt IE 9" gives <!--[if lt IE 9]><link ...><![endif]-->. Inline statics are
+// not wrapped.
+func WithConditionalComment(name, condition string) Option {
+	return func(c *config) { c.def(name).condition = condition }
+}
+
+// WithAsyncCSS links the named stylesheet without blocking rendering: as
+// <link rel="preload" as="style"> that switches itself to a stylesheet once
+// loaded, followed by a plain stylesheet <link> inside <noscript>. A "rel"
+// set by WithLinkAttrs is what the preload switches to.
+func WithAsyncCSS(name string) Option {
+	return func(c *config) { c.def(name).asyncCSS = true }
+}
+
+// WithInjectAfterHeadOpen injects auto tags immediately after the <head>
+// start tag, before any other head content, instead of before </head>.
+func WithInjectAfterHeadOpen() Option {
+	return func(c *config) { c.afterHeadOpen = true }
+}
+
+// WithInjectMarker injects auto tags in place of the first occurrence of
+// marker, such as "<!-- static -->", instead of before </head>. An HTML
+// comment is a good marker: if nothing is injected, html/template strips it
+// from the output.
+func WithInjectMarker(marker string) Option {
+	return func(c *config) { c.marker = marker }
+}
+
+// WithStrictInjection makes it an error if tags need auto-injecting but no
+// template contains the injection point, instead of silently dropping them.
+func WithStrictInjection() Option {
+	return func(c *config) { c.strictInject = true }
+}
+
+// WithTidyInjection collapses blank lines and stray whitespace around the
+// point where tags are auto-injected before </head> or </body>, or after
+// <head>, and indents the tags one level inside the element, so the output
+// reads as if the tags had been written there by hand. It has no effect on
+// WithInjectMarker.
+func WithTidyInjection() Option {
+	return func(c *config) { c.tidy = true }
+}
+
+// WithoutURLExtension drops the .css or .js extension (or the one set by
+// WithCSSExtension or WithJSExtension) from generated URLs, so main.css is
+// linked as /static/main, for servers that map clean URLs to files. Files
+// are still written with the extension.
+func WithoutURLExtension() Option {
+	return func(c *config) { c.noURLExt = true }
+}
+
+// WithoutFileExtension writes files without their extension as well, so
+// main.css is written as main and linked as /static/main; a CSS and a JS
+// static with the same name then collide. Servers must set the Content-Type
+// themselves, since it can no longer be derived from the file name.
+func WithoutFileExtension() Option {
+	return func(c *config) { c.noFileExt = true }
+}
+
+// WithInjectInto restricts auto-injection to the named templates, such as a
+// base layout, which are searched for the injection point in the order given
+// instead of every template by name. It is an error if one does not exist.
+func WithInjectInto(names ...string) Option {
+	return func(c *config) { c.injectInto = names }
+}
+
+// WithURLFunc generates each asset's URL with fn instead of joining
+// urlPrefix and the filename. fn is given the logical name, e.g. "main.css",
+// and the filename as written, relative to its output directory, e.g.
+// "css/main.3f2a9c1b.css", and returns the URL used in tags, the asset func,
+// and the manifest. WithRelativeURLs, WithoutURLExtension, and the urlPrefix
+// of Parse and WithDestination do not apply to the result.
+func WithURLFunc(fn func(logical, filename string) string) Option {
+	return func(c *config) { c.urlFunc = fn }
+}
+
+// WithURLPrefixTemplates treats the urlPrefix given to Parse, and those given
+// to WithDestination, as text/template text executed for each asset with a
+// URLData, so the prefix can vary by asset: "/static/{{slice .Hash 0 8}}"
+// links main.css as /static/3f2a9c1b/main.css, a cache-busting path that
+// leaves the filename alone. Files are still written to outputDir without
+// the extra segment, so whatever serves them must strip it. WithURLFunc, if
+// set, takes precedence.
+func WithURLPrefixTemplates() Option {
+	return func(c *config) { c.prefixTemplates = true }
+}
+
+// WithRelativeURLs drops the leading slash from generated URLs, so urlPrefix
+// "/static" yields "static/main.css". Relative URLs resolve against the
+// page's <base href>, if it has one, rather than the site root.
+func WithRelativeURLs() Option {
+	return func(c *config) { c.relativeURLs = true }
+}
+
+// WithKinds treats each named template as a static of the given kind
+// regardless of its name, e.g. {"vendor": KindCSS} for a definition
+// generated by another tool. Its filename is the whole name plus the kind's
+// extension, here vendor.css. Calling it again adds to the earlier names.
+func WithKinds(kinds map[string]Kind) Option {
+	return func(c *config) {
+		if c.forcedKinds == nil {
+			c.forcedKinds = make(map[string]Kind)
+		}
+		for name, kind := range kinds {
+			c.forcedKinds[name] = kind
+		}
+	}
+}
+
+// WithCSSExtension replaces ".css" in generated CSS filenames and URLs, e.g.
+// with ".min.css". The tag is still a stylesheet <link>.
+func WithCSSExtension(ext string) Option {
+	return withExtension(KindCSS, ext)
+}
+
+// WithJSExtension replaces ".js" in generated JS filenames and URLs, e.g.
+// with ".mjs". The tag is still a <script>.
+func WithJSExtension(ext string) Option {
+	return withExtension(KindJS, ext)
+}
+
+// WithIconExtension replaces ".ico" in generated icon filenames and URLs,
+// e.g. with ".png" or ".svg".
+func WithIconExtension(ext string) Option {
+	return withExtension(KindIcon, ext)
+}
+
+func withExtension(kind Kind, ext string) Option {
+	return func(c *config) {
+		if c.exts == nil {
+			c.exts = make(map[Kind]string)
+		}
+		c.exts[kind] = ext
+	}
+}
+
+// WithCSSDir writes CSS files to the slash-separated subdirectory dir of
+// outputDir, and adds it to their URLs: with "css", static-css-main is
+// written to css/main.css and linked as urlPrefix/css/main.css. Logical
+// names, as used by the asset func and the manifest, are unchanged.
+func WithCSSDir(dir string) Option {
+	return withSubdir(KindCSS, dir)
+}
+
+// WithJSDir is like WithCSSDir for JS files.
+func WithJSDir(dir string) Option {
+	return withSubdir(KindJS, dir)
+}
+
+// WithKindDirs puts every kind in a subdirectory of outputDir named after the
+// kind, as WithCSSDir("css") and WithJSDir("js") would: static-css-main is
+// written to css/main.css and linked as urlPrefix/css/main.css. A directory
+// set by WithCSSDir or WithJSDir takes precedence, in either order.
+func WithKindDirs() Option {
+	return func(c *config) {
+		if c.subdirs == nil {
+			c.subdirs = make(map[Kind]string)
+		}
+		for _, k := range kinds {
+			if _, ok := c.subdirs[k.kind]; !ok {
+				c.subdirs[k.kind] = string(k.kind)
+			}
+		}
+	}
+}
+
+func withSubdir(kind Kind, dir string) Option {
+	return func(c *config) {
+		if c.subdirs == nil {
+			c.subdirs = make(map[Kind]string)
+		}
+		c.subdirs[kind] = dir
+	}
+}
+
+// WithoutInjection only writes the static files. The returned template is a
+// clone of t in which static definitions keep their content and no tags are
+// injected, so assets must be referenced by hand, e.g. with the asset func.
+// The clone renders like t, but gains that func and the package's internal
+// funcs; as its statics are untouched, it may be passed to Parse again.
+func WithoutInjection() Option {
+	return func(c *config) { c.noInject = true }
+}
+
+// WithMinifier runs m over each rendered asset before it is hashed and
+// written. This package does not include a minifier.
+func WithMinifier(m Minifier) Option {
+	return func(c *config) { c.minifier = m }
+}
+
+// WithTransform runs fn over each rendered asset, before any minifier. It may
+// be given more than once; transforms run in order. An error aborts Parse,
+// wrapped with the static's name.
+//
+// Since transforms run before hashing, one that canonicalizes content, such
+// as sorting declarations produced in map order by a template func, keeps
+// fingerprints from changing between otherwise identical builds.
+func WithTransform(fn Transform) Option {
+	return func(c *config) { c.transforms = append(c.transforms, fn) }
+}
+
+// WithSourceMaps writes the source map returned by the minifier next to each
+// asset as main.css.map, and appends a sourceMappingURL comment pointing to it.
+// It has no effect without WithMinifier.
+func WithSourceMaps() Option {
+	return func(c *config) { c.sourceMaps = true }
+}
+
+// WithTrailingNewline makes every asset end in exactly one newline, adding
+// one if missing and trimming extras. It is applied last, after minification,
+// so it also holds for the bytes that are hashed.
+func WithTrailingNewline() Option {
+	return func(c *config) { c.trailingNewline = true }
+}
+
+// A FilenameCase selects how the name of a static after its prefix is turned
+// into a file name.
+type FilenameCase int
+
+const (
+	// KeepCase uses the name as written: static-css-MainTheme is
+	// MainTheme.css.
+	KeepCase FilenameCase = iota
+	// LowerCase lower-cases the name: static-css-MainTheme is maintheme.css.
+	LowerCase
+	// KebabCase splits camelCase words with hyphens and lower-cases them:
+	// static-css-MainTheme is main-theme.css and static-js-HTMLParser is
+	// html-parser.js.
+	KebabCase
+)
+
+// WithFilenameCase normalizes the logical name, file name, and URL derived
+// from each static's name. The default is KeepCase. Definitions and
+// per-static options still use the template name, and names set by
+// WithFilenameTemplate are used as rendered.
+func WithFilenameCase(fc FilenameCase) Option {
+	return func(c *config) { c.filenameCase = fc }
+}
+
+// A TagOrder selects how auto-injected tags not listed by WithOrder are
+// ordered.
+type TagOrder int
+
+const (
+	// CSSFirst injects all icons and manifests, then all stylesheets, then
+	// all scripts, each by name.
+	CSSFirst TagOrder = iota
+	// JSFirst injects all icons and manifests, then all scripts, then all
+	// stylesheets, each by name.
+	JSFirst
+	// SourceOrder injects tags in the order their statics are defined,
+	// regardless of kind. Definitions from different files are ordered by
+	// file name (the template's ParseName), then by position in the file.
+	SourceOrder
+)
+
+// WithTagOrder sets the order of auto-injected tags. The default is CSSFirst.
+func WithTagOrder(o TagOrder) Option {
+	return func(c *config) { c.tagOrder = o }
+}
+
+// WithOrder sets the order of auto-injected tags by template name, e.g.
+// WithOrder("static-js-loader", "static-css-main") to load a script before a
+// stylesheet. Statics not listed follow, ordered by WithTagOrder.
+func WithOrder(names ...string) Option {
+	return func(c *config) { c.order = names }
+}
+
+// WithPlaceOnce emits each explicitly placed tag at most once: only the first
+// {{template "static-*"}} call for a static renders the tag, and later calls
+// render nothing. "First" is by template name, then source order within a
+// template, so this suits sets whose calls for a static are all in one page
+// or layout; a page that renders only a later call gets no tag.
+func WithPlaceOnce() Option {
+	return func(c *config) { c.placeOnce = true }
+}
+
+// WithCSSTagTemplate replaces the <link> emitted for each static-css
+// definition with the output of text, an html/template executed with a
+// TagData, e.g. `<link rel="stylesheet" href="{{.URL}}" integrity="{{.Integrity}}">`.
+// Values are escaped for where they appear. Attributes set by WithLinkAttrs
+// are not added but are available as .Attrs, as in
+// `{{with .Attrs.media}} media="{{.}}"{{end}}`. WithAsyncCSS and WithXHTML do
+// not apply to templated tags, and inline statics keep their <style> element.
+func WithCSSTagTemplate(text string) Option {
+	return withTagTemplate(KindCSS, text)
+}
+
+// WithJSTagTemplate is like WithCSSTagTemplate for static-js definitions,
+// e.g. `<script type="module" src="{{.URL}}"></script>`.
+func WithJSTagTemplate(text string) Option {
+	return withTagTemplate(KindJS, text)
+}
+
+func withTagTemplate(kind Kind, text string) Option {
+	return func(c *config) {
+		if c.tagTexts == nil {
+			c.tagTexts = make(map[Kind]string)
+		}
+		c.tagTexts[kind] = text
+	}
+}
+
+// WithXHTML emits well-formed XML tags for XHTML documents: <link ... />
+// instead of <link ...>, and attr="" instead of a bare attribute.
+func WithXHTML() Option {
+	return func(c *config) { c.xhtml = true }
+}
+
+// A Placement selects where an auto-injected tag goes.
+type Placement int
+
+const (
+	// InHead injects the tag at the configured head injection point.
+	InHead Placement = iota
+	// AtBodyEnd injects the tag before </body>, e.g. for analytics scripts.
+	AtBodyEnd
+)
+
+// WithPlacement sets where the tag for the named static is auto-injected.
+// The default is InHead. It has no effect on statics placed with an explicit
+// {{template}} call. Tags at the body end keep the relative order of
+// WithOrder and WithTagOrder.
+func WithPlacement(name string, p Placement) Option {
+	return func(c *config) { c.def(name).placement = p }
+}
+
+// WithBundle emits the named members as a single asset called name, e.g.
+// WithBundle("static-js-vendor", "static-js-jquery", "static-js-plugins").
+// name must not itself be defined; it is placed or auto-injected like any
+// other static, while the members produce no file or tag of their own. All
+// members must be defined statics of name's kind, and a static can belong to
+// only one bundle.
+//
+// The bundle is the members' rendered content, concatenated in the order
+// given, each ending in a newline, and then minified and hashed as one asset.
+func WithBundle(name string, members ...string) Option {
+	return func(c *config) { c.bundles = append(c.bundles, bundle{name, members}) }
+}
+
+// WithDelims sets the action delimiters CheckDuplicates parses files with, to
+// match those given to html/template's Delims. Parse works on parsed
+// templates and needs no delimiters. An empty delimiter means the default.
+func WithDelims(left, right string) Option {
+	return func(c *config) { c.leftDelim, c.rightDelim = left, right }
+}
+
+// WithInPlace makes Parse modify t and return it, instead of a clone, saving
+// one of the two clones Parse makes, around 40% of the time Parse takes on a
+// large template set. Use it only when nothing else needs the original t: it
+// becomes the result, and a failed Parse may leave it half rewritten. The other
+// clone stays, since rendering the statics executes it and html/template cannot
+// modify an executed template. With WithoutStaticDefinitions the result is a
+// new template set as usual, built from t's modified trees.
+func WithInPlace() Option {
+	return func(c *config) { c.inPlace = true }
+}
+
+// WithSourceDefinitions keeps a copy of each static definition's original
+// content in the returned template, renamed with a "-source" suffix: executing
+// "static-css-main-source" renders the stylesheet live, unminified, e.g. for a
+// development endpoint. It is an error if such a name is already defined.
+func WithSourceDefinitions() Option {
+	return func(c *config) { c.keepSources = true }
+}
+
+// WithFilenameTemplate names the named static's file by executing text, a
+// text/template, with the data passed to Parse, and appending the extension:
+// with "app-{{.Version}}" and a Version of "3", static-js-app is written as
+// app-3.js. The logical name used by the asset func and the manifest is
+// unchanged. WithFingerprint still applies to the result.
+func WithFilenameTemplate(name, text string) Option {
+	return func(c *config) { c.def(name).filename = text }
+}
+
+// WithWatchInterval sets how often Watch reloads templates. The default is
+// 500ms. Other functions ignore it.
+func WithWatchInterval(d time.Duration) Option {
+	return func(c *config) { c.watchInterval = d }
+}
+
+// WithInline emits the named static's rendered, minified content inline, as
+// <style>...</style> or <script>...</script>, instead of writing a file and
+// linking it; e.g. for critical above-the-fold CSS. It gets no URL or
+// manifest entry and no source map.
+//
+// Where the static is placed with {{template "static-css-x" .}}, its
+// definition is rendered again on every Execute with the dot passed there, not
+// the data given to Parse, and run through transforms and the minifier; other
+// statics are only ever rendered with that data. Unlike theirs, its calls
+// inside a {{range}} body are kept and render once per iteration. An
+// auto-injected inline static has no call site, so it is rendered once with
+// the data given to Parse; its tag is template text, so html/template strips
+// any CSS or JS comments from it.
+func WithInline(name string) Option {
+	return func(c *config) { c.def(name).inline = true }
+}
+
+// WithInlineAll applies WithInline to every CSS and JS static, bundles
+// included, so no CSS or JS files are written; e.g. for email templates or
+// single-file HTML exports. Icons and manifests are still written as files.
+// Tags go where they would otherwise, so WithPlacement can still move
+// scripts to the body.
+func WithInlineAll() Option {
+	return func(c *config) { c.inlineAll = true }
+}
+
+// WithoutStaticDefinitions removes static definitions that no {{template}}
+// call refers to, such as those of auto-injected statics, from the returned
+// template instead of redefining them to empty, so they no longer appear in
+// its namespace. Explicitly placed definitions are kept.
+//
+// html/template cannot delete templates, so the returned set is rebuilt from
+// parse trees and does not inherit t's funcs or options such as missingkey.
+// Pass the funcs t was parsed with; the asset func is added as usual.
+func WithoutStaticDefinitions(funcs template.FuncMap) Option {
+	return func(c *config) { c.stripDefs, c.stripFuncs = true, funcs }
+}
+
+// WithDestination writes assets of the given kind to dir instead of
+// outputDir, and references them under urlPrefix instead of Parse's
+// urlPrefix: WithDestination(KindJS, "dist/js", "/js"). Manifest and Result
+// paths of those assets are relative to dir; AssetInfo.Dir says which.
+func WithDestination(kind Kind, dir, urlPrefix string) Option {
+	return func(c *config) {
+		if c.dests == nil {
+			c.dests = make(map[Kind]destination)
+		}
+		c.dests[kind] = destination{dir, urlPrefix}
+	}
+}
+
+// WithVerify refuses to overwrite a generated file that was edited since the
+// last build, such as a manual hotfix: before writing, each existing file is
+// checked against the hash recorded for it in the manifest, and Parse fails
+// if they differ. It requires WithManifest.
+func WithVerify() Option {
+	return func(c *config) { c.verify = true }
+}
+
+// WithPreview writes a combined, human-readable preview of every generated
+// asset to w once the build succeeds, for debugging: each static's name, file,
+// URL, and tag, followed by its content. It does not affect the files written.
+func WithPreview(w io.Writer) Option {
+	return func(c *config) { c.preview = w }
+}
+
+// WithStreamingWrites executes each static straight into a temporary file
+// next to its output rather than into memory, hashing the content as it is
+// written, then renames the file into place unless the existing file already
+// has the same hash. Use it for assets of many megabytes. The existing file's
+// mtime is kept, but an unchanged rebuild still writes and removes the
+// temporary file.
+//
+// Statics are streamed only when nothing needs their content in memory: not
+// with WithTransform, WithMinifier, WithTrailingNewline, WithFingerprintHash,
+// WithCompression, WithBanner, WithEmbedded, WithValidator, or WithPreview,
+// and not for inline statics or bundle members, which are buffered as usual.
+// A streamed asset has a nil AssetInfo.Content and is absent from Result.FS;
+// read it from its file instead.
+func WithStreamingWrites() Option {
+	return func(c *config) { c.streamWrites = true }
+}
+
+// WithCompression writes a precompressed sibling of each asset file, compressed
+// by c, for FileServer to serve: main.css.gz for "gzip" and main.css.br for
+// "br", the content codings FileServer knows. Use Gzip for gzip; the standard
+// library has no Brotli encoder, so bring one for "br". Siblings are written
+// only when their content changes, like the files themselves, and their sizes
+// are reported in AssetInfo.CompressedSizes and the manifest. Calling it again
+// for the same encoding replaces c.
+func WithCompression(encoding string, c Compressor) Option {
+	return func(cfg *config) {
+		var ext string
+		for _, e := range encodings {
+			if e.name == encoding {
+				ext = e.ext
+			}
+		}
+		cfg.compressors = slices.DeleteFunc(cfg.compressors, func(x compressor) bool { return x.encoding == encoding })
+		cfg.compressors = append(cfg.compressors, compressor{encoding: encoding, ext: ext, fn: c})
+	}
+}
+
+// WithBanner starts every asset file with a comment, /* ... */, whose text is
+// the text/template text executed with a BannerData, e.g.
+//
+//	generated by go-template-static at {{.Time.Format "2006-01-02"}} from {{.Name}}
+//
+// The banner is added after the minifier, which would strip it, and before
+// the file is hashed and fingerprinted, so Hash and fingerprints cover it.
+// Source maps are shifted to match. Inline statics get no banner. A banner
+// with .Time changes every build, so files are rewritten, and fingerprinted
+// URLs change, each time.
+func WithBanner(text string) Option {
+	return func(c *config) { c.bannerText = text }
+}
+
+// WithEmbedded makes Parse check each asset file, and source map, against
+// the copy in fsys at its File path instead of writing it, for assets shipped
+// in an embed.FS. Tags are injected and the manifest and other outputs
+// written as usual, but an asset missing from fsys or differing from it is an
+// error, so stale embedded files are caught. Compressed siblings are neither
+// written nor checked, and ModTime and Size come from fsys.
+func WithEmbedded(fsys fs.FS) Option {
+	return func(c *config) { c.embedded = fsys }
+}
+
+// WithBuildComment injects <!-- text --> with the auto-injected tags, before
+// them at the head injection point, e.g. "assets generated 2024-05-01" to
+// tell in the browser which build produced a page. It is injected even if
+// every tag is placed explicitly. text is used as is and must not contain
+// "--".
+func WithBuildComment(text string) Option {
+	return func(c *config) { c.buildComment = text }
+}
+
+// WithHeadTags injects html, as is, ahead of the auto-injected tags at the
+// head injection point, unless some template text already contains it,
+// ignoring case; e.g. `<meta charset="utf-8">` as a guard for pages that omit
+// it. Combine it with WithInjectAfterHeadOpen to keep a charset within the
+// first bytes of the document. Like WithBuildComment it is injected even if
+// every tag is placed explicitly. Repeated calls add tags in order.
+func WithHeadTags(html string) Option {
+	return func(c *config) { c.headTags = append(c.headTags, html) }
+}
+
+// WithWriteObserver calls written with each asset whose file the build wrote,
+// and skipped with each asset whose file already held its content, in the
+// order of Result.Assets, once all files are in place; e.g. for build logs or
+// warming a cache with just the changed URLs. Either may be nil. Inline
+// assets have no file and are passed to neither; an asset sharing the file
+// of another under WithDedupe, or checked against WithEmbedded, counts as
+// skipped.
+func WithWriteObserver(written, skipped func(AssetInfo)) Option {
+	return func(c *config) { c.onWritten, c.onSkipped = written, skipped }
+}
+
+// WithValidator runs v on the final content of every static, inline ones
+// included, before anything is written; an error aborts the build and names
+// the static. ValidateSyntax catches leaked {{actions}} and unbalanced CSS.
+// Validators run in the order given.
+func WithValidator(v Validator) Option {
+	return func(c *config) { c.validators = append(c.validators, v) }
+}
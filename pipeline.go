@@ -0,0 +1,152 @@
+package templatestatic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// A Minifier minifies the rendered content of one asset. It may also return
+// a source map for the minified output, or nil.
+type Minifier func(kind Kind, content []byte) (minified, sourceMap []byte, err error)
+
+// A Transform rewrites the rendered content of the named static, e.g. to add
+// vendor prefixes or a license banner.
+type Transform func(name string, content []byte) ([]byte, error)
+
+// A Compressor compresses the final content of an asset in one content
+// coding, for a precompressed sibling; see WithCompression.
+type Compressor func(content []byte) ([]byte, error)
+
+// compressor is a Compressor registered for a content coding, with the
+// extension of the siblings it writes.
+type compressor struct {
+	encoding, ext string
+	fn            Compressor
+}
+
+// Gzip is a Compressor for the gzip coding at the best compression level.
+// Its output does not depend on when it runs.
+func Gzip(content []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// process turns the rendered content of the named static into the bytes to
+// write. It returns the source map to write alongside, if any.
+func process(name string, kind Kind, logical string, content []byte, cfg *config) ([]byte, []byte, error) {
+	for _, transform := range cfg.transforms {
+		var err error
+		if content, err = transform(name, content); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Icons are often binary and manifests are JSON, which a banner or source
+	// map comment would break; write them as rendered.
+	if kind == KindIcon || kind == KindManifest {
+		return content, nil, nil
+	}
+	var sourceMap []byte
+	if cfg.minifier != nil {
+		var err error
+		content, sourceMap, err = cfg.minifier(kind, content)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	// Inline statics have no file to write a map next to.
+	if !cfg.sourceMaps || cfg.def(name).inline {
+		sourceMap = nil
+	}
+	if sourceMap != nil {
+		content = appendSourceMapURL(kind, content, path.Base(logical)+".map")
+	}
+	if cfg.bannerTmpl != nil && !cfg.def(name).inline {
+		banner, err := renderBanner(cfg, BannerData{Name: name, Logical: logical, Kind: kind, Time: cfg.buildTime, Hash: sha256Hex(content)})
+		if err != nil {
+			return nil, nil, fmt.Errorf("banner: %w", err)
+		}
+		content = append([]byte(banner), content...)
+		sourceMap = shiftSourceMap(sourceMap, strings.Count(banner, "\n"))
+	}
+	if cfg.trailingNewline {
+		content = append(bytes.TrimRight(content, "\r\n"), '\n')
+	}
+	return content, sourceMap, nil
+}
+
+// appendSourceMapURL appends a sourceMappingURL comment for url to content,
+// on its own line.
+func appendSourceMapURL(kind Kind, content []byte, url string) []byte {
+	out := append([]byte(nil), content...)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	if kind == KindCSS {
+		return append(out, "/*# sourceMappingURL="+url+" */"...)
+	}
+	return append(out, "//# sourceMappingURL="+url...)
+}
+
+// BannerData is the data a WithBanner template is executed with.
+type BannerData struct {
+	Name    string // template name, e.g. "static-css-main"
+	Logical string // logical file name, e.g. "main.css"
+	Kind    Kind
+	Time    time.Time // when the build started
+	Hash    string    // hex-encoded SHA-256 of the content without the banner
+}
+
+// renderBanner returns the banner for d as a comment on lines of its own,
+// ending in a newline. A "*/" in the text is broken up so it cannot end the
+// comment early.
+func renderBanner(cfg *config, d BannerData) (string, error) {
+	var b strings.Builder
+	if err := cfg.bannerTmpl.Execute(&b, d); err != nil {
+		return "", err
+	}
+	text := strings.ReplaceAll(strings.TrimRight(b.String(), "\r\n"), "*/", "* /")
+	return "/* " + text + " */\n", nil
+}
+
+// shiftSourceMap returns sourceMap with its mappings moved down n lines, for
+// content that gained n lines at the top. A nil map, or one that is not a
+// JSON object with a mappings string, is returned unchanged.
+func shiftSourceMap(sourceMap []byte, n int) []byte {
+	var m map[string]json.RawMessage
+	if sourceMap == nil || json.Unmarshal(sourceMap, &m) != nil {
+		return sourceMap
+	}
+	var mappings string
+	if json.Unmarshal(m["mappings"], &mappings) != nil {
+		return sourceMap
+	}
+	m["mappings"], _ = json.Marshal(strings.Repeat(";", n) + mappings)
+	out, err := json.Marshal(m)
+	if err != nil {
+		return sourceMap
+	}
+	return out
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of content.
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
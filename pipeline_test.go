@@ -0,0 +1,388 @@
+package templatestatic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stripSpaces is a toy Minifier that removes spaces and returns a fake map.
+func stripSpaces(kind Kind, content []byte) ([]byte, []byte, error) {
+	return bytes.ReplaceAll(content, []byte(" "), nil), []byte(`{"version":3,"kind":"` + string(kind) + `"}`), nil
+}
+
+func TestWithSourceMaps(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithMinifier(stripSpaces), WithSourceMaps(), WithFingerprint())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	tests := map[string]struct{ content, mapFile, mapContent string }{
+		"static-css-main": {"body{color:red;}\n/*# sourceMappingURL=main.css.map */", "main.css.map", `{"version":3,"kind":"css"}`},
+		"static-js-app":   {"console.log(\"hi\");\n//# sourceMappingURL=app.js.map", "app.js.map", `{"version":3,"kind":"js"}`},
+	}
+	for _, a := range res.Assets {
+		tt := tests[a.Name]
+		got, err := os.ReadFile(filepath.Join(outDir, a.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.content {
+			t.Errorf("%s = %q, want %q", a.File, got, tt.content)
+		}
+		if a.SourceMap != tt.mapFile {
+			t.Errorf("%s: SourceMap = %q, want %q", a.Name, a.SourceMap, tt.mapFile)
+		}
+		m, err := os.ReadFile(filepath.Join(outDir, tt.mapFile))
+		if err != nil {
+			t.Errorf("source map not written: %v", err)
+		} else if string(m) != tt.mapContent {
+			t.Errorf("%s = %q, want %q", tt.mapFile, m, tt.mapContent)
+		}
+	}
+
+	// Without WithSourceMaps, minified output has no map or comment.
+	outDir = t.TempDir()
+	res, err = Build(tmpl, nil, outDir, "/static", WithMinifier(stripSpaces))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		got, _ := os.ReadFile(filepath.Join(outDir, a.File))
+		if strings.Contains(string(got), "sourceMappingURL") || a.SourceMap != "" {
+			t.Errorf("%s has a source map without WithSourceMaps", a.Name)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, a.Logical+".map")); err == nil {
+			t.Errorf("%s.map written without WithSourceMaps", a.Logical)
+		}
+	}
+}
+
+func TestWithTrailingNewline(t *testing.T) {
+	const tmplStr = `{{define "static-css-none"}}a{}{{end}}
+{{define "static-css-one"}}b{}
+{{end}}
+{{define "static-css-many"}}c{}
+
+
+{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+
+	for _, tt := range []struct {
+		opts []Option
+		want map[string]string
+	}{
+		{nil, map[string]string{"none.css": "a{}", "one.css": "b{}\n", "many.css": "c{}\n\n\n"}},
+		{[]Option{WithTrailingNewline()}, map[string]string{"none.css": "a{}\n", "one.css": "b{}\n", "many.css": "c{}\n"}},
+	} {
+		outDir := t.TempDir()
+		res, err := Build(tmpl, nil, outDir, "/static", tt.opts...)
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		for _, a := range res.Assets {
+			got, _ := os.ReadFile(filepath.Join(outDir, a.File))
+			if want := tt.want[a.File]; string(got) != want {
+				t.Errorf("%s (%d opts) = %q, want %q", a.File, len(tt.opts), got, want)
+			}
+		}
+	}
+}
+
+func TestWithTransform(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	banner := func(name string, content []byte) ([]byte, error) {
+		return append([]byte("/*! "+name+" */\n"), content...), nil
+	}
+	upper := func(_ string, content []byte) ([]byte, error) { return bytes.ToUpper(content), nil }
+	_, err := Build(tmpl, nil, outDir, "/static", WithTransform(banner), WithTransform(upper), WithMinifier(stripSpaces))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "main.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Transforms run in order, then the minifier.
+	if want := "/*!STATIC-CSS-MAIN*/\nBODY{COLOR:RED;}"; string(got) != want {
+		t.Errorf("main.css = %q, want %q", got, want)
+	}
+
+	boom := errors.New("boom")
+	fail := func(name string, content []byte) ([]byte, error) {
+		if name == "static-js-app" {
+			return nil, boom
+		}
+		return content, nil
+	}
+	_, err = Build(tmpl, nil, t.TempDir(), "/static", WithTransform(fail))
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), `"static-js-app"`) {
+		t.Errorf("Build error = %v, want boom naming static-js-app", err)
+	}
+}
+
+// The fingerprint is taken from the bytes written, after transforms and the
+// minifier, so the filename always matches what is served.
+func TestFingerprintHashesMinifiedContent(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	upper := func(_ string, content []byte) ([]byte, error) { return bytes.ToUpper(content), nil }
+	res, err := Build(tmpl, nil, outDir, "/static", WithTransform(upper), WithMinifier(stripSpaces), WithFingerprint())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	raw := sha256.Sum256([]byte("body { color: red; }"))
+	minified := sha256.Sum256([]byte("BODY{COLOR:RED;}"))
+	want := "main." + hex.EncodeToString(minified[:])[:8] + ".css"
+	if raw == minified {
+		t.Fatal("test content is unaffected by the pipeline")
+	}
+
+	a := res.Assets[slices.IndexFunc(res.Assets, func(a AssetInfo) bool { return a.Name == "static-css-main" })]
+	if a.File != want {
+		t.Errorf("File = %s, want %s", a.File, want)
+	}
+	if a.Hash != hex.EncodeToString(minified[:]) {
+		t.Errorf("Hash = %s, want the hash of the minified content", a.Hash)
+	}
+	written, err := os.ReadFile(filepath.Join(outDir, a.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(written); sum != minified {
+		t.Errorf("written content %q does not hash to the fingerprint", written)
+	}
+}
+
+func TestWithFingerprintHash(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	fnv64 := func(content []byte) string {
+		h := fnv.New64a()
+		h.Write(content)
+		return fmt.Sprintf("%016x", h.Sum64())
+	}
+	res, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint(), WithFingerprintHash(fnv64))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		content, err := fs.ReadFile(res.FS(), a.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fingerprintName(a.Logical, fnv64(content))
+		if a.File != want {
+			t.Errorf("%s: File = %s, want %s", a.Name, a.File, want)
+		}
+		if !isFingerprinted(a.File) {
+			t.Errorf("%s: %s not recognized as fingerprinted", a.Name, a.File)
+		}
+		if sum := sha256.Sum256(content); a.Hash != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: Hash is not the SHA-256 of the content", a.Name)
+		}
+	}
+
+	bad := func([]byte) string { return "../x" }
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint(), WithFingerprintHash(bad)); err == nil {
+		t.Error("expected error for a fingerprint containing a path separator")
+	}
+}
+
+// A transform that canonicalizes content makes map-ordered output hash the
+// same on every build.
+
+func TestWithFingerprintLength(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	fnv64 := func(content []byte) string {
+		h := fnv.New64a()
+		h.Write(content)
+		return fmt.Sprintf("%016x", h.Sum64())
+	}
+	for _, tt := range []struct {
+		opts []Option
+		fp   func(content []byte, hash string) string
+	}{
+		{[]Option{WithFingerprintLength(12)}, func(_ []byte, hash string) string { return hash[:12] }},
+		{[]Option{WithFingerprintLength(64)}, func(_ []byte, hash string) string { return hash }},
+		{[]Option{WithFingerprintLength(10), WithFingerprintHash(fnv64)}, func(c []byte, _ string) string { return fnv64(c)[:10] }},
+	} {
+		res, err := Build(tmpl, nil, t.TempDir(), "/static", append(tt.opts, WithFingerprint(), WithManifest("manifest.json"))...)
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		for _, a := range res.Assets {
+			if want := fingerprintName(a.Logical, tt.fp(a.Content, a.Hash)); a.File != want || !strings.HasSuffix(a.URL, "/"+want) {
+				t.Errorf("%s: File, URL = %s, %s; want %s", a.Name, a.File, a.URL, want)
+			}
+		}
+	}
+	for _, n := range []int{4, 65} {
+		if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint(), WithFingerprintLength(n)); err == nil {
+			t.Errorf("length %d: expected error", n)
+		}
+	}
+}
+func TestTransformNormalizesContent(t *testing.T) {
+	vars := map[string]string{"--a": "1", "--b": "2", "--c": "3", "--d": "4"}
+	funcs := template.FuncMap{"vars": func() string {
+		var decls []string
+		for k, v := range vars {
+			decls = append(decls, k+":"+v+";")
+		}
+		return strings.Join(decls, "\n")
+	}}
+	tmpl := template.Must(template.New("test").Funcs(funcs).Parse(`{{define "static-css-vars"}}{{vars}}{{end}}`))
+	sortLines := func(_ string, content []byte) ([]byte, error) {
+		lines := strings.Split(string(content), "\n")
+		slices.Sort(lines)
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	var files []string
+	for i := 0; i < 10; i++ {
+		res, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint(), WithTransform(sortLines))
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		files = append(files, res.Assets[0].File)
+	}
+	if len(slices.Compact(slices.Clone(files))) != 1 {
+		t.Errorf("fingerprints differ across builds: %v", files)
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	// A stand-in for a Brotli encoder.
+	fakeBrotli := func(content []byte) ([]byte, error) { return append([]byte("br:"), content...), nil }
+	res, err := Build(tmpl, nil, outDir, "/static", WithManifest("manifest.json"),
+		WithCompression("gzip", Gzip), WithCompression("br", fakeBrotli))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]manifestEntry
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range res.Assets {
+		path := filepath.Join(outDir, a.File)
+		want := map[string]int64{}
+		for file, encoding := range map[string]string{path: "identity", path + ".gz": "gzip", path + ".br": "br"} {
+			fi, err := os.Stat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want[encoding] = fi.Size()
+		}
+		if a.Size != want["identity"] {
+			t.Errorf("%s: Size = %d, want %d", a.Name, a.Size, want["identity"])
+		}
+		if a.CompressedSizes["gzip"] != want["gzip"] || a.CompressedSizes["br"] != want["br"] || len(a.CompressedSizes) != 2 {
+			t.Errorf("%s: CompressedSizes = %v, want gzip and br of %v", a.Name, a.CompressedSizes, want)
+		}
+		if got := m[a.Logical].Sizes; !maps.Equal(got, want) {
+			t.Errorf("%s: manifest sizes = %v, want %v", a.Name, got, want)
+		}
+
+		f, err := os.Open(path + ".gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(zr)
+		f.Close()
+		if err != nil || !bytes.Equal(got, a.Content) {
+			t.Errorf("%s.gz decompresses to %q, %v; want %q", a.File, got, err, a.Content)
+		}
+	}
+
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithCompression("zstd", Gzip)); err == nil {
+		t.Error("expected error for an encoding FileServer does not serve")
+	}
+}
+
+func TestWithBanner(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	before := time.Now()
+	res, err := Build(tmpl, nil, outDir, "/static", WithFingerprint(),
+		WithBanner(`{{.Kind}} {{.Logical}} from {{.Name}} at {{.Time.Unix}}, content {{slice .Hash 0 8}} */`))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	body := map[string]string{"static-css-main": "body { color: red; }", "static-js-app": `console.log("hi");`}
+	for _, a := range res.Assets {
+		got, err := os.ReadFile(filepath.Join(outDir, a.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		banner, rest, ok := strings.Cut(string(got), "\n")
+		if !ok || rest != body[a.Name] {
+			t.Errorf("%s = %q, want banner line then %q", a.File, got, body[a.Name])
+			continue
+		}
+		var stamp int64
+		var kind, logical, name, hash string
+		if _, err := fmt.Sscanf(banner, "/* %s %s from %s at %d, content %s * / */", &kind, &logical, &name, &stamp, &hash); err != nil {
+			t.Errorf("%s: banner %q: %v", a.File, banner, err)
+			continue
+		}
+		if kind != string(a.Kind) || logical != a.Logical || name != a.Name || hash != sha256Hex([]byte(rest))[:8] {
+			t.Errorf("%s: banner %q has wrong fields", a.File, banner)
+		}
+		if stamp < before.Unix() || stamp > time.Now().Unix() {
+			t.Errorf("%s: banner time %d not during the build", a.File, stamp)
+		}
+		// The hash, and so the fingerprint, covers the banner.
+		if a.Hash != sha256Hex(got) || !strings.Contains(a.File, a.Hash[:8]) {
+			t.Errorf("%s: Hash %s is not of the file with its banner", a.File, a.Hash)
+		}
+	}
+
+	// Source maps move down past the banner.
+	mapTmpl := template.Must(template.New("test").Parse(`{{define "static-js-app"}}a(){{end}}`))
+	minify := func(kind Kind, content []byte) ([]byte, []byte, error) {
+		return content, []byte(`{"version":3,"mappings":"AAAA"}`), nil
+	}
+	outDir = t.TempDir()
+	if _, err := Build(mapTmpl, nil, outDir, "/static", WithMinifier(minify), WithSourceMaps(), WithBanner("line one\nline two")); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "app.js.map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"mappings":";;AAAA","version":3}`; string(got) != want {
+		t.Errorf("source map = %s, want %s", got, want)
+	}
+
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithBanner("{{.Missing}}")); err == nil {
+		t.Error("expected error for a banner field that does not exist")
+	}
+}
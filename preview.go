@@ -0,0 +1,34 @@
+package templatestatic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// writePreview writes a human-readable listing of statics to w: for each, its
+// name, file, and URL, how its tag is placed, the tag, and the content.
+func writePreview(w io.Writer, statics []staticDef, placed map[string]bool) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range statics {
+		where := "auto-injected"
+		if placed[s.name] {
+			where = "placed"
+		}
+		switch {
+		case s.inline:
+			fmt.Fprintf(bw, "==> %s (inline, %s)\n", s.name, where)
+		case s.shared:
+			fmt.Fprintf(bw, "==> %s: shares %s -> %s (%s)\n", s.name, s.filename, s.url, where)
+		default:
+			fmt.Fprintf(bw, "==> %s: %s -> %s (%s)\n", s.name, s.filename, s.url, where)
+		}
+		fmt.Fprintf(bw, "%s\n", s.tag)
+		bw.Write(s.content)
+		if len(s.content) > 0 && s.content[len(s.content)-1] != '\n' {
+			bw.WriteByte('\n')
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,43 @@
+package templatestatic
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWithPreview(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateExplicit))
+	var preview bytes.Buffer
+	outDir := t.TempDir()
+	if _, err := Build(tmpl, nil, outDir, "/static", WithPreview(&preview)); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := `==> static-css-critical: critical.css -> /static/critical.css (placed)
+<link rel="stylesheet" href="/static/critical.css">
+h1 { font-size: 2em; }
+
+==> static-js-app: app.js -> /static/app.js (auto-injected)
+<script src="/static/app.js"></script>
+console.log("hi");
+
+`
+	if got := preview.String(); got != want {
+		t.Errorf("preview:\n%s\nwant:\n%s", got, want)
+	}
+
+	withPreview, _ := os.ReadDir(outDir)
+	plainDir := t.TempDir()
+	if _, err := Build(tmpl, nil, plainDir, "/static"); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	plain, _ := os.ReadDir(plainDir)
+	if len(withPreview) != len(plain) {
+		t.Errorf("WithPreview wrote %d files, want %d", len(withPreview), len(plain))
+	}
+	if strings.Contains(preview.String(), "page") {
+		t.Error("preview lists a non-static template")
+	}
+}
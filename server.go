@@ -0,0 +1,152 @@
+package templatestatic
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cacheImmutable = "public, max-age=31536000, immutable"
+	cacheShort     = "public, max-age=300"
+)
+
+// contentTypes overrides the system MIME table, which varies by platform, for
+// the extensions Parse writes.
+var contentTypes = map[string]string{
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".ico":         "image/x-icon",
+	".webmanifest": "application/manifest+json",
+}
+
+// encodings lists precompressed sibling formats in order of preference.
+var encodings = []struct{ ext, name string }{
+	{".br", "br"},
+	{".gz", "gzip"},
+}
+
+// FileServer returns a handler that serves the files Parse wrote to outputDir
+// at the URLs it generated under urlPrefix.
+//
+// Fingerprinted files (see WithFingerprint) are served with a one-year
+// immutable Cache-Control; other files get a five-minute max-age. Directory
+// listings are not served, and neither are paths that extend urlPrefix's last
+// segment, such as /staticmain.css for /static.
+//
+// If a precompressed sibling such as main.css.br or main.css.gz exists and the
+// client accepts that encoding, it is served in place of main.css with the
+// matching Content-Encoding and Vary: Accept-Encoding. Siblings requested
+// directly are also served with their Content-Encoding and the Content-Type
+// of the uncompressed file.
+func FileServer(outputDir, urlPrefix string) http.Handler {
+	files := http.FileServer(http.Dir(outputDir))
+	return http.StripPrefix(strings.TrimSuffix(urlPrefix, "/"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A path that does not start with "/" matched urlPrefix only up to
+		// part of a segment, as /staticmain.css does /static.
+		if !strings.HasPrefix(r.URL.Path, "/") || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		h := w.Header()
+
+		// Work out the uncompressed name and, if the request is for a
+		// sibling, its encoding.
+		base, encoding := name, ""
+		for _, e := range encodings {
+			if strings.HasSuffix(name, e.ext) {
+				base, encoding = strings.TrimSuffix(name, e.ext), e.name
+				break
+			}
+		}
+		if encoding == "" {
+			vary := false
+			for _, e := range encodings {
+				if !fileExists(filepath.Join(outputDir, filepath.FromSlash(name+e.ext))) {
+					continue
+				}
+				vary = true
+				if acceptsEncoding(r.Header.Get("Accept-Encoding"), e.name) {
+					name, encoding = name+e.ext, e.name
+					break
+				}
+			}
+			if vary {
+				h.Set("Vary", "Accept-Encoding")
+			}
+		}
+
+		if ct, ok := contentTypes[path.Ext(base)]; ok {
+			h.Set("Content-Type", ct)
+		}
+		if encoding != "" {
+			h.Set("Content-Encoding", encoding)
+		}
+		if isFingerprinted(base) {
+			h.Set("Cache-Control", cacheImmutable)
+		} else {
+			h.Set("Cache-Control", cacheShort)
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = name
+		r2.URL.RawPath = ""
+		files.ServeHTTP(w, r2)
+	}))
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value accepts
+// the named content coding with a nonzero quality.
+func acceptsEncoding(header, name string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), name) {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		return !ok || strings.Trim(q, "0.") != ""
+	}
+	return false
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// LinkHeader returns a Link header value preloading every asset with a URL,
+// e.g. `</static/main.css>; rel=preload; as=style, </static/app.js>;
+// rel=preload; as=script`, for a 103 Early Hints response sent before the page
+// is rendered. Where the built-in tag carries a crossorigin attribute, from
+// WithIntegrity or WithLinkAttrs, the link gets the same value, e.g.
+// crossorigin=anonymous, or the browser would not reuse the preload. Inline
+// assets, icons, and manifests are left out, as are repeats of a URL shared
+// by WithDedupe. It returns "" if there is nothing to preload.
+func (r *Result) LinkHeader() string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, a := range r.Assets {
+		if a.URL == "" || a.Kind == KindIcon || a.Kind == KindManifest || seen[a.URL] {
+			continue
+		}
+		seen[a.URL] = true
+		as := "style"
+		if a.Kind == KindJS {
+			as = "script"
+		}
+		link := "<" + a.URL + ">; rel=preload; as=" + as
+		if v := r.crossOrigins[a.Name]; v != "" {
+			link += "; crossorigin=" + v
+		}
+		links = append(links, link)
+	}
+	return strings.Join(links, ", ")
+}
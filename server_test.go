@@ -0,0 +1,163 @@
+package templatestatic
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFileServer(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	plain, err := Build(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	hashed, err := Build(tmpl, nil, outDir, "/static", WithFingerprint())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	srv := FileServer(outDir, "/static")
+	tests := []struct {
+		url, contentType, cacheControl string
+	}{
+		{plain.Assets[0].URL, "text/css; charset=utf-8", cacheShort},
+		{plain.Assets[1].URL, "text/javascript; charset=utf-8", cacheShort},
+		{hashed.Assets[0].URL, "text/css; charset=utf-8", cacheImmutable},
+		{hashed.Assets[1].URL, "text/javascript; charset=utf-8", cacheImmutable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d", tt.url, rec.Code)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("GET %s: Content-Type = %q, want %q", tt.url, got, tt.contentType)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("GET %s: Cache-Control = %q, want %q", tt.url, got, tt.cacheControl)
+		}
+	}
+
+	for _, url := range []string{"/static/", "/static", "/staticmain.css", "/staticfoo"} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", url, rec.Code)
+		}
+	}
+}
+
+func TestFingerprintName(t *testing.T) {
+	const hash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	for in, want := range map[string]string{
+		"main.css":        "main.9f86d081.css",
+		"themes/dark.css": "themes/dark.9f86d081.css",
+	} {
+		got := fingerprintName(in, hash[:8])
+		if got != want {
+			t.Errorf("fingerprintName(%q) = %q, want %q", in, got, want)
+		}
+		if !isFingerprinted(got) {
+			t.Errorf("isFingerprinted(%q) = false", got)
+		}
+	}
+	if isFingerprinted("main.css") {
+		t.Error(`isFingerprinted("main.css") = true`)
+	}
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+	outDir := t.TempDir()
+	for name, content := range map[string]string{
+		"main.css":    "plain",
+		"main.css.gz": "gzip",
+		"main.css.br": "brotli",
+		"app.js":      "plain",
+		"app.js.gz":   "gzip",
+	} {
+		if err := os.WriteFile(filepath.Join(outDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := FileServer(outDir, "/static")
+	tests := []struct {
+		url, accept          string
+		body, encoding, vary string
+		contentType          string
+	}{
+		{"/static/main.css", "", "plain", "", "Accept-Encoding", "text/css; charset=utf-8"},
+		{"/static/main.css", "gzip", "gzip", "gzip", "Accept-Encoding", "text/css; charset=utf-8"},
+		{"/static/main.css", "gzip, br", "brotli", "br", "Accept-Encoding", "text/css; charset=utf-8"},
+		{"/static/main.css", "br;q=0, gzip", "gzip", "gzip", "Accept-Encoding", "text/css; charset=utf-8"},
+		{"/static/app.js", "br", "plain", "", "Accept-Encoding", "text/javascript; charset=utf-8"},
+		{"/static/app.js", "GZIP;q=0.5", "gzip", "gzip", "Accept-Encoding", "text/javascript; charset=utf-8"},
+		{"/static/main.css.gz", "", "gzip", "gzip", "", "text/css; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("GET %s (Accept-Encoding %q): body = %q, want %q", tt.url, tt.accept, got, tt.body)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("GET %s (Accept-Encoding %q): Content-Encoding = %q, want %q", tt.url, tt.accept, got, tt.encoding)
+		}
+		if got := rec.Header().Get("Vary"); got != tt.vary {
+			t.Errorf("GET %s (Accept-Encoding %q): Vary = %q, want %q", tt.url, tt.accept, got, tt.vary)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("GET %s (Accept-Encoding %q): Content-Type = %q, want %q", tt.url, tt.accept, got, tt.contentType)
+		}
+	}
+}
+
+func TestLinkHeader(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{}{{end}}
+{{define "static-css-same"}}a{}{{end}}
+{{define "static-css-critical"}}h1{}{{end}}
+{{define "static-js-app"}}go(){{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	res, err := Build(tmpl, nil, t.TempDir(), "https://cdn.example.com/s",
+		WithFingerprint(), WithDedupe(), WithInline("static-css-critical"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	css := res.Assets[slices.IndexFunc(res.Assets, func(a AssetInfo) bool { return a.Name == "static-css-main" })]
+	js := res.Assets[slices.IndexFunc(res.Assets, func(a AssetInfo) bool { return a.Name == "static-js-app" })]
+	want := "<" + css.URL + ">; rel=preload; as=style; crossorigin=anonymous, <" + js.URL + ">; rel=preload; as=script; crossorigin=anonymous"
+	if got := res.LinkHeader(); got != want {
+		t.Errorf("LinkHeader() = %s\nwant %s", got, want)
+	}
+
+	// Same-origin tags carry no crossorigin unless WithLinkAttrs sets one.
+	res, err = Build(tmpl, nil, t.TempDir(), "/static", WithInline("static-css-critical"),
+		WithLinkAttrs("static-css-main", map[string]string{"crossorigin": "use-credentials"}))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want = "</static/main.css>; rel=preload; as=style; crossorigin=use-credentials, </static/same.css>; rel=preload; as=style, </static/app.js>; rel=preload; as=script"
+	if got := res.LinkHeader(); got != want {
+		t.Errorf("LinkHeader() = %s\nwant %s", got, want)
+	}
+
+	empty, err := Build(template.Must(template.New("test").Parse(`<html></html>`)), nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if got := empty.LinkHeader(); got != "" {
+		t.Errorf("LinkHeader() with no assets = %q, want empty", got)
+	}
+}
@@ -0,0 +1,246 @@
+package templatestatic
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"html/template"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// attrNamePattern is deliberately stricter than HTML so names can never break
+// out of the tag.
+var attrNamePattern = regexp.MustCompile(`^[a-zA-Z_:][-a-zA-Z0-9_:.]*$`)
+
+// TagData is the data a tag template set by WithCSSTagTemplate or
+// WithJSTagTemplate is executed with.
+type TagData struct {
+	Name      string // template name, e.g. "static-css-main"
+	Kind      Kind
+	URL       string // the asset's URL
+	Hash      string // hex-encoded SHA-256 of the content, as in AssetInfo
+	Integrity string // subresource integrity value, e.g. "sha384-..."
+
+	// Attrs holds the attributes set by WithLinkAttrs for the static, or
+	// nil; missing keys index as "".
+	Attrs map[string]string
+}
+
+// tag returns the tag that references the non-inline static s: its kind's
+// tag template executed for s, or else the built-in tag, wrapped in any
+// conditional comment set by WithConditionalComment.
+func (c *config) tag(s *staticDef) (string, error) {
+	d := c.def(s.name)
+	var tag string
+	if t, ok := c.tagTmpls[s.kind]; ok {
+		var b strings.Builder
+		err := t.Execute(&b, TagData{Name: s.name, Kind: s.kind, URL: s.url, Hash: s.hash, Integrity: s.integrity, Attrs: d.linkAttrs})
+		if err != nil {
+			return "", err
+		}
+		tag = b.String()
+	} else {
+		var sri string
+		if c.wantIntegrity(s.url) {
+			sri = s.integrity
+		}
+		var err error
+		if tag, err = buildTag(s.kind, s.url, sri, d, c.xhtml); err != nil {
+			return "", err
+		}
+	}
+	if d.condition == "" {
+		return tag, nil
+	}
+	if !conditionPattern.MatchString(d.condition) {
+		return "", fmt.Errorf("invalid conditional comment condition %q", d.condition)
+	}
+	return "<!--[if " + d.condition + "]>" + tag + "<![endif]-->", nil
+}
+
+// conditionPattern matches the conditions of IE conditional comments, such
+// as "lt IE 9" or "(gte IE 6)&(lte IE 8)", and nothing that could end the
+// comment.
+var conditionPattern = regexp.MustCompile(`^[!()&| A-Za-z0-9.]+$`)
+
+// integrity returns the subresource integrity value for content, using
+// SHA-384 unless WithIntegrityHash chose another algorithm.
+func (c *config) integrity(content []byte) string {
+	h, prefix := c.integrityHasher()
+	h.Write(content)
+	return prefix + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// integrityHasher returns a new hash for integrity values and the prefix
+// naming it.
+func (c *config) integrityHasher() (hash.Hash, string) {
+	switch c.integrityHash {
+	case crypto.SHA256:
+		return sha256.New(), "sha256-"
+	case crypto.SHA512:
+		return sha512.New(), "sha512-"
+	}
+	return sha512.New384(), "sha384-"
+}
+
+// wantIntegrity reports whether the built-in tag for url carries integrity
+// and crossorigin attributes under the configured IntegrityMode.
+func (c *config) wantIntegrity(url string) bool {
+	switch c.integrityMode {
+	case IntegrityAlways:
+		return true
+	case IntegrityNever:
+		return false
+	}
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "//")
+}
+
+// crossOrigin returns the crossorigin value of the built-in tag for the
+// non-inline static s, or "" if the tag has none. A preload must send the
+// same value for the browser to reuse its response.
+func (c *config) crossOrigin(s *staticDef) string {
+	if _, ok := c.tagTmpls[s.kind]; ok {
+		return ""
+	}
+	// Only stylesheet tags take link attributes; otherwise crossorigin comes
+	// with integrity, as in buildTag.
+	v, ok := c.def(s.name).linkAttrs["crossorigin"]
+	if !ok || s.kind != KindCSS {
+		if s.kind != KindCSS && s.kind != KindJS || !c.wantIntegrity(s.url) || s.integrity == "" {
+			return ""
+		}
+		v = "anonymous"
+	}
+	if v == "" {
+		// An empty value means anonymous.
+		v = "anonymous"
+	}
+	return v
+}
+
+// linkRels holds the default rel of the kinds linked by a plain <link>.
+var linkRels = map[Kind]string{
+	KindIcon:     "icon",
+	KindManifest: "manifest",
+}
+
+// buildTag returns the tag that references url for a static of the given kind,
+// with integrity and crossorigin attributes if sri is not empty.
+// With xhtml the tag is well-formed XML: <link> is self-closed and empty
+// attribute values are written out.
+func buildTag(kind Kind, url, sri string, d *defConfig, xhtml bool) (string, error) {
+	esc := template.HTMLEscapeString
+	var sriAttrs string
+	var skip []string
+	if sri != "" {
+		crossorigin := "anonymous"
+		if v, ok := d.linkAttrs["crossorigin"]; ok && kind == KindCSS {
+			crossorigin = v
+		}
+		sriAttrs = ` integrity="` + esc(sri) + `" crossorigin="` + esc(crossorigin) + `"`
+		skip = []string{"integrity", "crossorigin"}
+	}
+	if kind == KindJS {
+		return `<script src="` + esc(url) + `"` + sriAttrs + `></script>`, nil
+	}
+	if rel, ok := linkRels[kind]; ok {
+		if r, ok := d.linkAttrs["rel"]; ok {
+			rel = r
+		}
+		return linkTag(rel, url, "", d.linkAttrs, xhtml)
+	}
+
+	rel := "stylesheet"
+	if r, ok := d.linkAttrs["rel"]; ok {
+		rel = r
+	}
+	if !d.asyncCSS {
+		extra := sriAttrs
+		if rel == "preload" {
+			// Without as, a preload is fetched again when it is used.
+			extra = ` as="style"` + extra
+		}
+		return linkTag(rel, url, extra, d.linkAttrs, xhtml, skip...)
+	}
+	// Load as a preload and apply on load; browsers without scripts get the
+	// plain stylesheet.
+	preload, err := linkTag("preload", url, ` as="style"`+sriAttrs+` onload="this.onload=null;this.rel='`+esc(rel)+`'"`, d.linkAttrs, xhtml, skip...)
+	if err != nil {
+		return "", err
+	}
+	fallback, err := linkTag(rel, url, sriAttrs, d.linkAttrs, xhtml, skip...)
+	if err != nil {
+		return "", err
+	}
+	return preload + "<noscript>" + fallback + "</noscript>", nil
+}
+
+// linkTag returns a <link> with the given rel and href, then extra, already
+// escaped, then attrs other than those and skip.
+func linkTag(rel, url, extra string, attrs map[string]string, xhtml bool, skip ...string) (string, error) {
+	esc := template.HTMLEscapeString
+	var b strings.Builder
+	b.WriteString(`<link rel="` + esc(rel) + `" href="` + esc(url) + `"` + extra)
+	if err := writeAttrs(&b, attrs, xhtml, append(skip, "rel", "href", "as", "onload")...); err != nil {
+		return "", err
+	}
+	if xhtml {
+		b.WriteString(" />")
+	} else {
+		b.WriteString(">")
+	}
+	return b.String(), nil
+}
+
+// inlineTag returns a <style> or <script> element containing content, which
+// must not contain the element's end tag. Script content must not contain
+// "<!--" either: followed by "<script" it would stop the end tag from closing
+// the element.
+func inlineTag(kind Kind, content []byte) (string, error) {
+	if _, ok := linkRels[kind]; ok {
+		return "", fmt.Errorf("%s statics cannot be inlined", kind)
+	}
+	elem := "style"
+	if kind == KindJS {
+		elem = "script"
+	}
+	if bytes.Contains(bytes.ToLower(content), []byte("</"+elem)) {
+		return "", fmt.Errorf("inline content contains </%s", elem)
+	}
+	if kind == KindJS && bytes.Contains(content, []byte("<!--")) {
+		return "", errors.New("inline content contains <!--")
+	}
+	return "<" + elem + ">" + string(content) + "</" + elem + ">", nil
+}
+
+// writeAttrs writes attrs to b in sorted order, skipping the named
+// attributes, which the caller has already written. An empty value is written
+// as a bare attribute, or as name="" with xhtml.
+func writeAttrs(b *strings.Builder, attrs map[string]string, xhtml bool, skip ...string) error {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !attrNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid attribute name %q", name)
+		}
+		if slices.Contains(skip, name) {
+			continue
+		}
+		b.WriteString(" " + name)
+		if v := attrs[name]; v != "" || xhtml {
+			b.WriteString(`="` + template.HTMLEscapeString(v) + `"`)
+		}
+	}
+	return nil
+}
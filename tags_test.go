@@ -0,0 +1,777 @@
+package templatestatic
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithLinkAttrs(t *testing.T) {
+	const tmplStr = `{{define "static-css-x"}}a{{end}}
+{{define "static-css-alt"}}b{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static",
+		WithLinkAttrs("static-css-x", map[string]string{"data-turbo-track": "reload"}),
+		WithLinkAttrs("static-css-alt", map[string]string{"rel": "alternate stylesheet", "title": `"Dark" & <light>`, "disabled": ""}),
+	)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`<link rel="stylesheet" href="/static/x.css" data-turbo-track="reload">`,
+		`<link rel="alternate stylesheet" href="/static/alt.css" disabled title="&#34;Dark&#34; &amp; &lt;light&gt;">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %s\ngot: %s", want, out)
+		}
+	}
+}
+
+func TestWithRel(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{{end}}
+{{define "static-css-dark"}}b{{end}}
+{{define "static-css-fonts"}}c{{end}}
+{{define "page"}}<html><head>{{template "static-css-dark"}}</head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static",
+		WithRel("static-css-dark", "alternate stylesheet"), WithRel("static-css-fonts", "preload"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, want := range []string{
+		`<link rel="alternate stylesheet" href="/static/dark.css">`, // placed
+		`<link rel="preload" href="/static/fonts.css" as="style">`,  // auto-injected
+		`<link rel="stylesheet" href="/static/main.css">`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+}
+
+func TestIconKind(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{{end}}
+{{define "static-icon-favicon"}}<svg xmlns="http://www.w3.org/2000/svg"></svg>{{end}}
+{{define "page"}}<html><head><title>T</title></head></html>{{end}}`
+	tests := []struct {
+		opts []Option
+		file string
+		want string
+	}{
+		{nil, "favicon.ico", `<link rel="icon" href="/static/favicon.ico"><link rel="stylesheet" href="/static/main.css"></head>`},
+		{[]Option{WithIconExtension(".svg"), WithTagOrder(JSFirst), WithLinkAttrs("static-icon-favicon", map[string]string{"type": "image/svg+xml"})},
+			"favicon.svg", `<link rel="icon" href="/static/favicon.svg" type="image/svg+xml"><link rel="stylesheet" href="/static/main.css"></head>`},
+	}
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("test").Parse(tmplStr))
+		outDir := t.TempDir()
+		rt, err := Parse(tmpl, nil, outDir, "/static", append(tt.opts, WithMinifier(stripSpaces))...)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		out := strings.NewReplacer("\n", "", "  ", "").Replace(buf.String())
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("output missing %s\ngot: %s", tt.want, out)
+		}
+		// Icons skip the minifier.
+		got, err := os.ReadFile(filepath.Join(outDir, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `<svg xmlns="http://www.w3.org/2000/svg"></svg>`; string(got) != want {
+			t.Errorf("%s = %q, want %q", tt.file, got, want)
+		}
+	}
+}
+
+func TestManifestKind(t *testing.T) {
+	const tmplStr = `{{define "static-js-app"}}a(){{end}}
+{{define "static-icon-favicon"}}i{{end}}
+{{define "static-manifest-app"}}{"name": "{{.}}", "display": "standalone"}{{end}}
+{{define "page"}}<html><head><title>T</title></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, "App", outDir, "/static", WithValidator(ValidateJSON), WithTagOrder(JSFirst))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "app.webmanifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"name": "App", "display": "standalone"}`; string(got) != want {
+		t.Errorf("app.webmanifest = %q, want %q", got, want)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := strings.NewReplacer("\n", "", "  ", "").Replace(buf.String())
+	want := `<link rel="icon" href="/static/favicon.ico"><link rel="manifest" href="/static/app.webmanifest"><script src="/static/app.js"></script></head>`
+	if !strings.Contains(out, want) {
+		t.Errorf("output missing %s\ngot: %s", want, out)
+	}
+
+	bad := template.Must(template.New("test").Parse(`{{define "static-manifest-app"}}{"name": }{{end}}`))
+	if _, err := Parse(bad, nil, t.TempDir(), "/static", WithValidator(ValidateJSON)); err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Errorf("malformed manifest: err = %v, want invalid JSON", err)
+	}
+}
+
+func TestWithConditionalComment(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{{end}}
+{{define "static-css-ie"}}b{{end}}
+{{define "static-js-shim"}}c(){{end}}
+{{define "static-js-app"}}d(){{end}}
+{{define "page"}}<html><head><title>T</title></head><body>{{template "static-js-shim"}}</body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static",
+		WithConditionalComment("static-css-ie", "lt IE 9"),
+		WithConditionalComment("static-js-shim", "(gte IE 6)&(lte IE 8)"),
+		WithPlacement("static-js-app", AtBodyEnd))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := `<html><head><title>T</title>
+  <!--[if lt IE 9]><link rel="stylesheet" href="/static/ie.css"><![endif]-->
+  <link rel="stylesheet" href="/static/main.css">
+</head><body><!--[if (gte IE 6)&(lte IE 8)]><script src="/static/shim.js"></script><![endif]-->
+  <script src="/static/app.js"></script>
+</body></html>`
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithConditionalComment("static-css-ie", "IE]><script>")); err == nil {
+		t.Error("expected error for a condition that ends the comment")
+	}
+}
+
+func TestWithLinkAttrsInvalidName(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(`{{define "static-css-x"}}a{{end}}`))
+	_, err := Parse(tmpl, nil, t.TempDir(), "/static",
+		WithLinkAttrs("static-css-x", map[string]string{`onload="x`: ""}))
+	if err == nil {
+		t.Error("expected error for invalid attribute name")
+	}
+}
+
+// Tag text is never parsed as a template, so delimiters in it are literal.
+func TestTagTextNotParsed(t *testing.T) {
+	const tmplStr = `{{define "static-css-x"}}a{{end}}
+{{define "page"}}<html><head>{{template "static-css-x"}}</head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/{{.}}")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", "data"); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := `<link rel="stylesheet" href="/{{.}}/x.css">`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("output missing %s\ngot: %s", want, buf.String())
+	}
+}
+
+func TestAssetURL(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		relative bool
+		want     string
+	}{
+		{"/static", false, "/static/main.css"},
+		{"/static/", false, "/static/main.css"},
+		{"https://cdn.example.com", false, "https://cdn.example.com/main.css"},
+		{"/static", true, "static/main.css"},
+		{"static", true, "static/main.css"},
+		{"/", true, "main.css"},
+		{"", true, "main.css"},
+	}
+	for _, tt := range tests {
+		got := assetURL(tt.prefix, "main.css", &config{relativeURLs: tt.relative})
+		if got != tt.want {
+			t.Errorf("assetURL(%q, relative=%v) = %q, want %q", tt.prefix, tt.relative, got, tt.want)
+		}
+	}
+}
+
+func TestWithRelativeURLs(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	tests := []struct {
+		prefix string
+		opts   []Option
+		css    string
+		js     string
+	}{
+		{"/static", nil, "/static/main.css", "/static/app.js"},
+		{"/static", []Option{WithRelativeURLs()}, "static/main.css", "static/app.js"},
+		{"./static", []Option{WithRelativeURLs()}, "./static/main.css", "./static/app.js"},
+		{"", []Option{WithRelativeURLs()}, "main.css", "app.js"},
+		{"/static", []Option{WithRelativeURLs(), WithCSSDir("css"), WithIntegrity(IntegrityNever), WithURLFunc(cdnURL)},
+			"https://cdn.example.com/main.css?f=css/main.css", "https://cdn.example.com/app.js?f=app.js"},
+	}
+	for _, tt := range tests {
+		rt, err := Parse(tmpl, nil, t.TempDir(), tt.prefix, tt.opts...)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		for _, want := range []string{
+			`<link rel="stylesheet" href="` + tt.css + `">`,
+			`<script src="` + tt.js + `"></script>`,
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("prefix %q, %d opts: output missing %s\ngot: %s", tt.prefix, len(tt.opts), want, buf.String())
+			}
+		}
+	}
+}
+
+func cdnURL(logical, filename string) string {
+	return "https://cdn.example.com/" + logical + "?f=" + filename
+}
+
+func TestWithURLPrefixTemplates(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	res, err := Build(tmpl, nil, t.TempDir(), "/static/{{slice .Hash 0 8}}", WithURLPrefixTemplates(),
+		WithDestination(KindJS, t.TempDir(), "/{{.Kind}}/{{slice .Hash 0 8}}"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, a := range res.Assets {
+		want := "/static/" + a.Hash[:8] + "/main.css"
+		if a.Kind == KindJS {
+			want = "/js/" + a.Hash[:8] + "/app.js"
+		}
+		if a.URL != want {
+			t.Errorf("%s: URL = %q, want %q", a.Name, a.URL, want)
+		}
+		if !strings.Contains(buf.String(), `="`+want+`"`) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static/{{.Nope}}", WithURLPrefixTemplates()); err == nil {
+		t.Error("expected error for an unknown field in the urlPrefix template")
+	}
+}
+
+func TestWithURLFuncEmpty(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	_, err := Parse(tmpl, nil, t.TempDir(), "/static", WithURLFunc(func(string, string) string { return "" }))
+	if err == nil || !strings.Contains(err.Error(), "empty URL") {
+		t.Errorf("err = %v, want an empty URL error", err)
+	}
+}
+
+func TestWithExtensions(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, nil, outDir, "/static", WithCSSExtension(".min.css"), WithJSExtension(".mjs"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, name := range []string{"main.min.css", "app.mjs"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, want := range []string{
+		`<link rel="stylesheet" href="/static/main.min.css">`,
+		`<script src="/static/app.mjs"></script>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+
+	for _, ext := range []string{"mjs", ".", "./x", ""} {
+		if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithJSExtension(ext)); err == nil {
+			t.Errorf("WithJSExtension(%q): expected error", ext)
+		}
+	}
+}
+
+func TestTagShapes(t *testing.T) {
+	const tmplStr = `{{define "static-css-x"}}a{{end}}
+{{define "static-js-app"}}b{{end}}
+{{define "page"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml"><head profile="http://gmpg.org/xfn/11">
+<title>t</title>
+</head><body/></html>{{end}}`
+	attrs := WithLinkAttrs("static-css-x", map[string]string{"disabled": ""})
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"html5", []Option{attrs}, []string{
+			`<link rel="stylesheet" href="/static/x.css" disabled>`,
+			`<script src="/static/app.js"></script>`,
+		}},
+		{"xhtml", []Option{attrs, WithXHTML()}, []string{
+			`<link rel="stylesheet" href="/static/x.css" disabled="" />`,
+			`<script src="/static/app.js"></script>`,
+		}},
+		{"xhtml after head open", []Option{attrs, WithXHTML(), WithInjectAfterHeadOpen()}, []string{
+			`<head profile="http://gmpg.org/xfn/11">` + "\n" + `  <link rel="stylesheet" href="/static/x.css" disabled="" />`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("test").Parse(tmplStr))
+			rt, err := Parse(tmpl, nil, t.TempDir(), "/static", tt.opts...)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+				t.Fatalf("ExecuteTemplate: %v", err)
+			}
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output missing %s\ngot: %s", want, out)
+				}
+			}
+			if i, j := strings.Index(out, "x.css"), strings.Index(out, "</head>"); i < 0 || i > j {
+				t.Errorf("link not inside head\n%s", out)
+			}
+		})
+	}
+}
+
+func TestWithInline(t *testing.T) {
+	const tmplStr = `{{define "static-css-critical"}}h1 { margin: 0; }{{end}}
+{{define "static-css-main"}}p { color: red; }{{end}}
+{{define "page"}}<html><head>
+</head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithInline("static-css-critical"),
+		WithMinifier(stripSpaces), WithSourceMaps(), WithManifest("manifest.json"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<style>h1{margin:0;}</style>`,
+		`<link rel="stylesheet" href="/static/main.css">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %s\ngot: %s", want, out)
+		}
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	if got, want := strings.Join(files, " "), "main.css main.css.map manifest.json"; got != want {
+		t.Errorf("outputDir = %s, want %s", got, want)
+	}
+	manifest, err := os.ReadFile(filepath.Join(outDir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(manifest), "critical") {
+		t.Errorf("manifest lists the inline asset:\n%s", manifest)
+	}
+
+	breakout := template.Must(template.New("test").Parse(`{{define "static-css-x"}}a{}</STYLE><script>{{end}}`))
+	if _, err := Parse(breakout, nil, t.TempDir(), "/static", WithInline("static-css-x")); err == nil {
+		t.Error("expected error for inline content containing </style")
+	}
+}
+
+func TestWithInlineAll(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := filepath.Join(t.TempDir(), "out")
+	rt, err := Parse(tmpl, nil, outDir, "/static", WithInlineAll(), WithPlacement("static-js-app", AtBodyEnd))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Errorf("outputDir created: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	style, head := strings.Index(out, `<style>body { color: red; }</style>`), strings.Index(out, "</head>")
+	script, body := strings.Index(out, `<script>console.log("hi");</script>`), strings.Index(out, "</body>")
+	if style < 0 || style > head || script < head || script > body {
+		t.Errorf("want the style in head and the script in body\ngot: %s", out)
+	}
+
+	breakout := template.Must(template.New("test").Parse(`{{define "static-js-x"}}a("<!--<script>"){{end}}`))
+	if _, err := Parse(breakout, nil, t.TempDir(), "/static", WithInlineAll()); err == nil {
+		t.Error("expected error for inline script containing <!--")
+	}
+}
+
+func TestWithAsyncCSS(t *testing.T) {
+	const tmplStr = `{{define "static-css-x"}}a{{end}}
+{{define "static-css-y"}}b{{end}}
+{{define "page"}}<html><head>
+<title>t</title>
+</head><body></body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithAsyncCSS("static-css-x"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	want := `<link rel="preload" href="/static/x.css" as="style" onload="this.onload=null;this.rel='stylesheet'">` +
+		`<noscript><link rel="stylesheet" href="/static/x.css"></noscript>`
+	if !strings.Contains(out, want) {
+		t.Errorf("output missing %s\ngot: %s", want, out)
+	}
+	if !strings.Contains(out, `<link rel="stylesheet" href="/static/y.css">`) {
+		t.Errorf("other stylesheet not linked normally\ngot: %s", out)
+	}
+	if i, j := strings.Index(out, "<noscript>"), strings.Index(out, "</head>"); i < 0 || i > j {
+		t.Errorf("async link not inside head\n%s", out)
+	}
+}
+
+// A placed inline static is rendered at request time with the dot passed to
+// it; an auto-injected one has no call site and keeps the build-time data.
+func TestWithInlineRequestTime(t *testing.T) {
+	const tmplStr = `{{define "static-css-critical"}}h1 { color: {{.}}; }{{end}}
+{{define "static-js-boot"}}var c = "{{.}}";{{end}}
+{{define "page"}}<html><head>{{with .Color}}{{template "static-css-critical" .}}{{end}}
+</head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	rt, err := Parse(tmpl, "build", t.TempDir(), "/static", WithMinifier(stripSpaces),
+		WithInline("static-css-critical"), WithInline("static-js-boot"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, color := range []string{"red", "blue"} {
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, "page", map[string]string{"Color": color}); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		for _, want := range []string{
+			`<style>h1{color:` + color + `;}</style>`,
+			`<script>varc="build";</script>`,
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("output missing %s\ngot: %s", want, buf.String())
+			}
+		}
+	}
+}
+
+func TestWithInlineInRange(t *testing.T) {
+	const tmplStr = `{{define "static-css-row"}}.row-{{.}} { color: {{.}}; }{{end}}
+{{define "page"}}<html><head>
+</head><body>{{range .}}{{template "static-css-row" .}}<p class="row-{{.}}"></p>{{end}}</body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	res, err := Build(tmpl, nil, t.TempDir(), "/static", WithMinifier(stripSpaces), WithInline("static-css-row"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if !res.Assets[0].Placed {
+		t.Error("inline call inside range not reported as placed")
+	}
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", []string{"red", "blue"}); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "<style>"); n != 2 {
+		t.Errorf("got %d style elements, want one per iteration\n%s", n, out)
+	}
+	for _, color := range []string{"red", "blue"} {
+		if want := `<style>.row-` + color + `{color:` + color + `;}</style><p class="row-` + color + `">`; !strings.Contains(out, want) {
+			t.Errorf("output missing %s\ngot: %s", want, out)
+		}
+	}
+}
+
+func TestWithoutExtension(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	tests := []struct {
+		name  string
+		opts  []Option
+		files []string
+		css   string
+		js    string
+	}{
+		{"url only", []Option{WithoutURLExtension()}, []string{"app.js", "main.css"}, "/static/main", "/static/app"},
+		{"url only, custom extension", []Option{WithoutURLExtension(), WithJSExtension(".mjs")}, []string{"app.mjs", "main.css"}, "/static/main", "/static/app"},
+		{"on disk", []Option{WithoutFileExtension()}, []string{"app", "main"}, "/static/main", "/static/app"},
+		{"on disk, fingerprinted", []Option{WithoutFileExtension(), WithFingerprint()}, nil, "/static/main.", "/static/app."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			res, err := Build(tmpl, nil, outDir, "/static", tt.opts...)
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			for _, a := range res.Assets {
+				want := tt.css
+				if a.Kind == KindJS {
+					want = tt.js
+				}
+				if !strings.HasPrefix(a.URL, want) || strings.HasSuffix(a.URL, "."+string(a.Kind)) {
+					t.Errorf("%s: URL = %s, want %s without extension", a.Name, a.URL, want)
+				}
+				if _, err := os.Stat(filepath.Join(outDir, a.File)); err != nil {
+					t.Errorf("%s: %v", a.Name, err)
+				}
+			}
+			if tt.files != nil {
+				entries, err := os.ReadDir(outDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var files []string
+				for _, e := range entries {
+					files = append(files, e.Name())
+				}
+				if got, want := strings.Join(files, " "), strings.Join(tt.files, " "); got != want {
+					t.Errorf("outputDir = %s, want %s", got, want)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+				t.Fatalf("ExecuteTemplate: %v", err)
+			}
+			if !strings.Contains(buf.String(), `href="`+tt.css) || !strings.Contains(buf.String(), `src="`+tt.js) {
+				t.Errorf("tags do not use the URLs\n%s", buf.String())
+			}
+		})
+	}
+
+	same := template.Must(template.New("test").Parse(`{{define "static-css-x"}}a{{end}}{{define "static-js-x"}}b{{end}}`))
+	for _, opt := range []Option{WithoutURLExtension(), WithoutFileExtension()} {
+		if _, err := Parse(same, nil, t.TempDir(), "/static", opt); err == nil {
+			t.Error("expected error for a CSS and a JS static with the same URL")
+		}
+	}
+}
+
+func TestTagTemplates(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	res, err := Build(tmpl, nil, t.TempDir(), "https://cdn.example.com/s?v=1&x",
+		WithCSSTagTemplate(`<link href='{{.URL}}' rel='stylesheet' integrity='{{.Integrity}}' crossorigin='anonymous'/>`),
+		WithJSTagTemplate(`<script type="module" data-name="{{.Name}}" src="{{.URL}}"></script>`))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	sum := sha512.Sum384([]byte("body { color: red; }"))
+	sri := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	for _, want := range []string{
+		// html/template writes the + of base64 as &#43;, which browsers decode.
+		`<link href='https://cdn.example.com/s?v=1&amp;x/main.css' rel='stylesheet' integrity='` + strings.ReplaceAll(sri, "+", "&#43;") + `' crossorigin='anonymous'/>`,
+		`<script type="module" data-name="static-js-app" src="https://cdn.example.com/s?v=1&amp;x/app.js"></script>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+	for _, a := range res.Assets {
+		if a.Kind == KindCSS && a.Integrity != sri {
+			t.Errorf("Integrity = %s, want %s", a.Integrity, sri)
+		}
+	}
+
+	// Hash and WithLinkAttrs values are available to the template.
+	const twoCSS = `{{define "static-css-main"}}a{}{{end}}
+{{define "static-css-print"}}b{}{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	res, err = Build(template.Must(template.New("test").Parse(twoCSS)), nil, t.TempDir(), "/static",
+		WithCSSTagTemplate(`<link rel="stylesheet" href="{{.URL}}" data-hash="{{.Hash}}"{{with .Attrs.media}} media="{{.}}"{{end}}>`),
+		WithLinkAttrs("static-css-print", map[string]string{"media": "print"}))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	buf.Reset()
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, a := range res.Assets {
+		want := `<link rel="stylesheet" href="` + a.URL + `" data-hash="` + a.Hash + `">`
+		if a.Name == "static-css-print" {
+			want = `<link rel="stylesheet" href="` + a.URL + `" data-hash="` + a.Hash + `" media="print">`
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+
+	for _, text := range []string{`<link href="{{.URL}">`, `<link href="{{.Nope}}">`} {
+		if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithCSSTagTemplate(text)); err == nil {
+			t.Errorf("%s: expected error", text)
+		}
+	}
+}
+
+func TestWithIntegrityHash(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	content := []byte("body { color: red; }")
+	s256, s384, s512 := sha256.Sum256(content), sha512.Sum384(content), sha512.Sum512(content)
+	tests := []struct {
+		opts []Option
+		want string
+	}{
+		{nil, "sha384-" + base64.StdEncoding.EncodeToString(s384[:])},
+		{[]Option{WithIntegrityHash(crypto.SHA256)}, "sha256-" + base64.StdEncoding.EncodeToString(s256[:])},
+		{[]Option{WithIntegrityHash(crypto.SHA512)}, "sha512-" + base64.StdEncoding.EncodeToString(s512[:])},
+	}
+	for _, tt := range tests {
+		res, err := Build(tmpl, nil, t.TempDir(), "/static", tt.opts...)
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		if got := res.Assets[0].Integrity; got != tt.want {
+			t.Errorf("Integrity = %s, want %s", got, tt.want)
+		}
+	}
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithIntegrityHash(crypto.SHA1)); err == nil {
+		t.Error("expected error for SHA-1")
+	}
+}
+
+func TestWithIntegrity(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	tests := []struct {
+		prefix string
+		opts   []Option
+		sri    bool
+	}{
+		{"/static", nil, false},
+		{"https://cdn.example.com/static", nil, true},
+		{"http://cdn.example.com/static", nil, true},
+		{"//cdn.example.com/static", nil, true},
+		{"/static", []Option{WithIntegrity(IntegrityAlways)}, true},
+		{"https://cdn.example.com/static", []Option{WithIntegrity(IntegrityNever)}, false},
+	}
+	for _, tt := range tests {
+		res, err := Build(tmpl, nil, t.TempDir(), tt.prefix, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: Build: %v", tt.prefix, err)
+		}
+		var buf bytes.Buffer
+		if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		for _, a := range res.Assets {
+			var want string
+			switch {
+			case a.Kind == KindJS && tt.sri:
+				want = `<script src="` + a.URL + `" integrity="` + a.Integrity + `" crossorigin="anonymous"></script>`
+			case a.Kind == KindJS:
+				want = `<script src="` + a.URL + `"></script>`
+			case tt.sri:
+				want = `<link rel="stylesheet" href="` + a.URL + `" integrity="` + a.Integrity + `" crossorigin="anonymous">`
+			default:
+				want = `<link rel="stylesheet" href="` + a.URL + `">`
+			}
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s %v: output missing %s\ngot: %s", tt.prefix, tt.opts, want, buf.String())
+			}
+		}
+	}
+}
+
+func TestWithFilenameCase(t *testing.T) {
+	const tmplStr = `{{define "static-css-MainTheme"}}a{}{{end}}
+{{define "static-js-admin/HTMLParser2Go"}}b(){{end}}
+{{define "page"}}<html><head></head><body>{{asset "main-theme.css"}}</body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(FuncMap()).Parse(tmplStr))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, nil, outDir, "/static", WithFilenameCase(KebabCase))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, name := range []string{"main-theme.css", "admin/html-parser2-go.js"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, want := range []string{
+		`<link rel="stylesheet" href="/static/main-theme.css">`,
+		`<script src="/static/admin/html-parser2-go.js"></script>`,
+		`<body>/static/main-theme.css</body>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+
+	for in, want := range map[FilenameCase]string{KeepCase: "MainTheme", LowerCase: "maintheme", KebabCase: "main-theme"} {
+		if got := in.apply("MainTheme"); got != want {
+			t.Errorf("%d.apply(MainTheme) = %s, want %s", in, got, want)
+		}
+	}
+}
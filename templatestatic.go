@@ -1,117 +1,1248 @@
 package templatestatic
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/token"
 	"html/template"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	texttemplate "text/template"
 	"text/template/parse"
+	"time"
 )
 
-// Parse clones t, extracts templates named static-css-* and static-js-*,
-// writes them as files to outputDir, and returns a new template with
-// <link>/<script> tags injected before </head> (CSS first, then JS).
+// Kind identifies the type of a static asset.
+type Kind string
+
+const (
+	KindCSS      Kind = "css"
+	KindJS       Kind = "js"
+	KindIcon     Kind = "icon"     // linked as rel="icon", e.g. a favicon
+	KindManifest Kind = "manifest" // a web app manifest, linked as rel="manifest"
+)
+
+// AssetInfo describes a static file generated by Build.
+type AssetInfo struct {
+	Name    string // template name, e.g. "static-css-main"
+	Kind    Kind
+	Logical string // File without any fingerprint, e.g. "main.css"
+	Dir     string // Result.OutputDir, or the kind's WithDestination directory, made absolute
+	File    string // slash-separated path relative to Dir, e.g. "main.1a2b3c4d.css"
+	URL     string // URL referenced by the generated tag
+	Hash    string // hex-encoded SHA-256 of the file content
+	ETag    string // Hash as a quoted entity tag, suitable for an ETag header
+	Placed  bool   // tag is emitted by an explicit {{template}} call, not auto-injected
+
+	// ModTime is the file's modification time after Build, which is kept
+	// across builds while its content is unchanged; suitable for a
+	// Last-Modified header.
+	ModTime time.Time
+
+	// Size is the size of File in bytes. CompressedSizes holds the size of
+	// each compressed sibling written by WithCompression, keyed by content
+	// coding, e.g. "gzip"; it is nil without WithCompression.
+	Size            int64
+	CompressedSizes map[string]int64
+
+	// Content is the asset's final content, after transforms and the
+	// minifier, as written to File; for an inline asset, as rendered with
+	// the data given to Build. It is shared with FS and must not be
+	// modified.
+	Content []byte
+
+	// Integrity is the subresource integrity value of Content, e.g.
+	// "sha384-...", as given to tag templates.
+	Integrity string
+
+	// SourceMap is the slash-separated path of the source map written
+	// alongside the asset, relative to Dir, or "" if there is none.
+	SourceMap string
+
+	// Inline assets (see WithInline) have no File or URL.
+}
+
+// Result is the output of Build.
+type Result struct {
+	Template  *template.Template // template ready for rendering
+	Assets    []AssetInfo        // generated assets, sorted by Name
+	OutputDir string             // outputDir as an absolute path
+
+	contents     map[string][]byte // asset File -> content, for FS
+	crossOrigins map[string]string // asset Name -> crossorigin of its tag, for LinkHeader
+
+	// NearMisses lists template names that resemble, but do not match, a
+	// static prefix. It is only populated with WithNearMissDetection.
+	NearMisses []string
+
+	// Injections is the number of places auto-injected tags were spliced
+	// into: 0 if there were none to inject or no injection point was found,
+	// 1 for the head, and 2 if WithPlacement also sent some before </body>.
+	Injections int
+}
+
+// Parse clones t, extracts templates named static-css-*, static-js-*, and
+// static-icon-*, writes them as files to outputDir, and returns a new
+// template with <link>/<script> tags injected before </head> (icons first,
+// then CSS, then JS).
 //
 // If a static definition has an explicit {{template "static-css-*"}} call
 // in the template tree, the tag appears there instead of being auto-injected.
 //
-// The original template t is not modified.
-func Parse(t *template.Template, data any, outputDir, urlPrefix string) (*template.Template, error) {
+// A static defined in several files of a ParseFS or ParseGlob set has only
+// its last definition left in t, so Parse cannot report the others; use
+// CheckDuplicates on the same files to find them.
+//
+// The original template t is not modified unless WithInPlace is given, but it
+// must not have been executed: html/template cannot clone a template after
+// its first Execute.
+func Parse(t *template.Template, data any, outputDir, urlPrefix string, opts ...Option) (*template.Template, error) {
+	return ParseContext(context.Background(), t, data, outputDir, urlPrefix, opts...)
+}
+
+// ParseContext is like Parse but stops rendering static definitions once ctx
+// is done, returning ctx.Err(). The context is checked between definitions;
+// template funcs that should observe it must receive it through data, e.g.
+// {{fetch $.Ctx "theme"}} with data holding a Ctx field.
+func ParseContext(ctx context.Context, t *template.Template, data any, outputDir, urlPrefix string, opts ...Option) (*template.Template, error) {
+	res, err := BuildContext(ctx, t, data, outputDir, urlPrefix, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return res.Template, nil
+}
+
+// Extract renders the static definitions of t and writes them to outputDir
+// like Build, and returns the metadata of each generated asset, but leaves
+// the template alone: no result template is built and no tags are injected.
+// Options that only affect the result template, such as WithPlacement or
+// WithoutStaticDefinitions, have no effect. t is still cloned for rendering, so
+// the restriction on executed templates described for Parse applies.
+func Extract(t *template.Template, data any, outputDir, urlPrefix string, opts ...Option) ([]AssetInfo, error) {
+	return ExtractContext(context.Background(), t, data, outputDir, urlPrefix, opts...)
+}
+
+// ExtractContext is like Extract but observes ctx as described for
+// ParseContext.
+func ExtractContext(ctx context.Context, t *template.Template, data any, outputDir, urlPrefix string, opts ...Option) ([]AssetInfo, error) {
+	opts = append(opts[:len(opts):len(opts)], func(c *config) { c.extractOnly = true })
+	res, err := BuildContext(ctx, t, data, outputDir, urlPrefix, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return res.Assets, nil
+}
+
+// staticDef is a static definition and its rendered content.
+type staticDef struct {
+	name, logical, filename string
+	dir                     string // output directory filename is relative to
+	url, tag                string
+	kind                    Kind
+	content                 []byte
+	hash                    string
+	integrity               string
+	sourceMap               []byte // written to mapFile() if non-nil
+	modTime                 time.Time
+	size                    int64
+	compressedSizes         map[string]int64 // by content coding
+
+	// tmpFile, if set, holds the content, which was streamed there rather
+	// than kept in memory; see WithStreamingWrites. content is then nil.
+	tmpFile string
+
+	// inline statics are emitted in their tag and have no file or URL.
+	inline bool
+	// shared statics reuse the file of an earlier static with identical
+	// content, and are not written themselves.
+	shared bool
+
+	// parseName and pos locate the definition in its source, for SourceOrder.
+	parseName string
+	pos       parse.Pos
+}
+
+// mapFile returns the path of the source map for s, relative to s.dir: next
+// to the asset, named after the logical name rather than the fingerprinted
+// one, because the asset's content, and so its fingerprint, includes a
+// reference to it.
+func (s *staticDef) mapFile() string {
+	return path.Join(path.Dir(s.filename), path.Base(s.logical)+".map")
+}
+
+// Build is like Parse but also returns metadata about each generated asset.
+func Build(t *template.Template, data any, outputDir, urlPrefix string, opts ...Option) (*Result, error) {
+	return BuildContext(context.Background(), t, data, outputDir, urlPrefix, opts...)
+}
+
+// BuildContext is like Build but observes ctx as described for ParseContext.
+func BuildContext(ctx context.Context, t *template.Template, data any, outputDir, urlPrefix string, opts ...Option) (*Result, error) {
+	cfg := newConfig(opts)
+
+	// Resolve directories once, so everything after refers to the same place
+	// even if the working directory changes.
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("templatestatic: outputDir: %w", err)
+	}
+	for kind, d := range cfg.dests {
+		if d.dir, err = filepath.Abs(d.dir); err != nil {
+			return nil, fmt.Errorf("templatestatic: %s destination: %w", kind, err)
+		}
+		cfg.dests[kind] = d
+	}
+
+	for kind, ext := range cfg.exts {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext, `/\`) {
+			return nil, fmt.Errorf("templatestatic: invalid %s extension %q", kind, ext)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.forcedKinds)) {
+		kind := cfg.forcedKinds[name]
+		if kindIndex(kind) < 0 {
+			return nil, fmt.Errorf("templatestatic: WithKinds: unknown kind %q for %q", kind, name)
+		}
+	}
+	for kind, dir := range cfg.subdirs {
+		if !safeSuffix(dir) {
+			return nil, fmt.Errorf("templatestatic: %s directory %q is not inside outputDir", kind, dir)
+		}
+	}
+	for kind, text := range cfg.tagTexts {
+		tagTmpl, err := template.New(string(kind)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("templatestatic: %s tag template: %w", kind, err)
+		}
+		if cfg.tagTmpls == nil {
+			cfg.tagTmpls = make(map[Kind]*template.Template)
+		}
+		cfg.tagTmpls[kind] = tagTmpl
+	}
+	if cfg.bannerText != "" {
+		if cfg.bannerTmpl, err = texttemplate.New("banner").Option("missingkey=error").Parse(cfg.bannerText); err != nil {
+			return nil, fmt.Errorf("templatestatic: banner: %w", err)
+		}
+		cfg.buildTime = time.Now()
+	}
+	if h := cfg.integrityHash; h != 0 && h != crypto.SHA256 && h != crypto.SHA384 && h != crypto.SHA512 {
+		return nil, fmt.Errorf("templatestatic: integrity hash %v is not SHA-256, SHA-384, or SHA-512", h)
+	}
+	for _, name := range cfg.injectInto {
+		if t.Lookup(name) == nil {
+			return nil, fmt.Errorf("templatestatic: WithInjectInto: no template %q", name)
+		}
+	}
+	for _, c := range cfg.compressors {
+		if c.ext == "" {
+			return nil, fmt.Errorf("templatestatic: unsupported compression encoding %q", c.encoding)
+		}
+	}
+	if strings.Contains(cfg.buildComment, "--") {
+		return nil, fmt.Errorf("templatestatic: build comment %q contains \"--\"", cfg.buildComment)
+	}
+	if cfg.manifestOwner != "" && cfg.manifest == "" {
+		return nil, errors.New("templatestatic: WithMergeManifest requires WithManifest")
+	}
+	if n := cfg.fingerprintLen; n != 0 && (n < 8 || n > 64) {
+		return nil, fmt.Errorf("templatestatic: fingerprint length %d is not between 8 and 64", n)
+	}
+	if cfg.verify && cfg.manifest == "" {
+		return nil, errors.New("templatestatic: WithVerify requires WithManifest")
+	}
+	if cfg.goConstFile != "" && (!token.IsIdentifier(cfg.goConstPkg) || cfg.goConstPkg == "_") {
+		return nil, fmt.Errorf("templatestatic: invalid Go package name %q", cfg.goConstPkg)
+	}
+
+	if cfg.allowedRoot != "" {
+		dirs := []string{outputDir}
+		for _, d := range cfg.dests {
+			dirs = append(dirs, d.dir)
+		}
+		for _, dir := range dirs {
+			if err := checkWithinRoot(cfg.allowedRoot, "outputDir", dir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, f := range []struct{ opt, name string }{
+		{"WithManifest", cfg.manifest},
+		{"WithChecksums", cfg.checksums},
+		{"WithGoConstants", cfg.goConstFile},
+	} {
+		if f.name == "" {
+			continue
+		}
+		if err := checkOutputFile(f.opt, outputDir, f.name, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Lookup(resultMarker) != nil {
+		return nil, errors.New("templatestatic: template is already the result of Parse; pass the original template instead")
+	}
+
 	// Use one clone to render template content (Execute prevents later Parse).
-	renderClone, err := t.Clone()
+	renderClone, err := clone(t)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.inlineAll {
+		var names []string
+		for _, tmpl := range renderClone.Templates() {
+			names = append(names, tmpl.Name())
+		}
+		for _, b := range cfg.bundles {
+			names = append(names, b.name)
+		}
+		for _, name := range names {
+			if k, ok := cfg.kindOf(name); ok && (k.kind == KindCSS || k.kind == KindJS) {
+				cfg.def(name).inline = true
+			}
+		}
+	}
+
+	// Collect static definitions and their rendered content.
+	var statics []staticDef
+	var nearMisses []string
+	var errs []error
+	fail := func(err error) error {
+		if !cfg.allErrors {
+			return err
+		}
+		errs = append(errs, err)
+		return nil
+	}
+
+	// Streamed content waits in temporary files until it is renamed into
+	// place; remove whatever is left if the build stops early.
+	var temps []string
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+
+	// add runs raw, the rendered content of the named static, through the
+	// pipeline and records the result. src locates its definition. If tmp is
+	// not nil the content was streamed to it instead, and raw is unused.
+	add := func(name string, raw []byte, tmp *tempAsset, src *parse.Tree) error {
+		k, _ := cfg.kindOf(name)
+		kind := k.kind
+		logical := cfg.filenameCase.apply(strings.TrimPrefix(name, k.prefix)) + cfg.extension(k)
+		d := cfg.def(name)
+		var content, sourceMap []byte
+		var hash, sri string
+		if tmp != nil {
+			hash, sri = tmp.hash, tmp.integrity
+		} else {
+			var err error
+			if content, sourceMap, err = process(name, kind, logical, raw, cfg); err != nil {
+				return fail(fmt.Errorf("templatestatic: %q: %w", name, err))
+			}
+			for _, validate := range cfg.validators {
+				if err := validate(name, kind, content); err != nil {
+					return fail(fmt.Errorf("templatestatic: %q: %w", name, err))
+				}
+			}
+			// Hash the final bytes, so a fingerprint always matches the file.
+			sum := sha256.Sum256(content)
+			hash, sri = hex.EncodeToString(sum[:]), cfg.integrity(content)
+		}
+
+		if d.inline {
+			tag, err := inlineTag(kind, content)
+			if err != nil {
+				return fail(fmt.Errorf("templatestatic: %q: %w", name, err))
+			}
+			statics = append(statics, staticDef{
+				name:      name,
+				logical:   logical,
+				tag:       tag,
+				kind:      kind,
+				content:   content,
+				hash:      hash,
+				integrity: sri,
+				inline:    true,
+				parseName: src.ParseName,
+				pos:       src.Root.Pos,
+			})
+			return nil
+		}
+
+		filename := logical
+		if text := d.filename; text != "" {
+			var err error
+			if filename, err = renderFilename(text, data); err != nil {
+				return fail(fmt.Errorf("templatestatic: %q: %w", name, err))
+			}
+			filename += cfg.extension(k)
+		}
+		if dir := cfg.subdirs[kind]; dir != "" {
+			filename = dir + "/" + filename
+		}
+		if cfg.fingerprint {
+			fp := cfg.fingerprintOf(content, hash)
+			if !validFingerprint.MatchString(fp) {
+				return fail(fmt.Errorf("templatestatic: %q: invalid fingerprint %q", name, fp))
+			}
+			filename = fingerprintName(filename, fp)
+		}
+		if cfg.noFileExt {
+			filename = strings.TrimSuffix(filename, cfg.extension(k))
+		}
+
+		dir, prefix := outputDir, urlPrefix
+		if d, ok := cfg.dests[kind]; ok {
+			dir, prefix = d.dir, d.urlPrefix
+		}
+		if cfg.prefixTemplates {
+			var err error
+			if prefix, err = renderURLPrefix(prefix, URLData{Name: name, Logical: logical, Kind: kind, Hash: hash}); err != nil {
+				return fail(fmt.Errorf("templatestatic: %q: %w", name, err))
+			}
+		}
+		urlName := filename
+		if cfg.noURLExt {
+			urlName = strings.TrimSuffix(filename, cfg.extension(k))
+		}
+		url := assetURL(prefix, urlName, cfg)
+		if cfg.urlFunc != nil {
+			if url = cfg.urlFunc(logical, filename); url == "" {
+				return fail(fmt.Errorf("templatestatic: %q: URL func returned an empty URL", name))
+			}
+		}
+		s := staticDef{
+			name:      name,
+			logical:   logical,
+			dir:       dir,
+			filename:  filename,
+			url:       url,
+			kind:      kind,
+			content:   content,
+			hash:      hash,
+			integrity: sri,
+			sourceMap: sourceMap,
+			parseName: src.ParseName,
+			pos:       src.Root.Pos,
+		}
+		if tmp != nil {
+			s.tmpFile = tmp.path
+		}
+		var err error
+		if s.tag, err = cfg.tag(&s); err != nil {
+			return fail(fmt.Errorf("templatestatic: %q: %w", name, err))
+		}
+		statics = append(statics, s)
+		return nil
+	}
+
+	if err := checkBundles(t, cfg); err != nil {
+		return nil, err
+	}
+	for _, err := range undefinedCalls(t, cfg) {
+		if err := fail(err); err != nil {
+			return nil, err
+		}
+	}
+	// Bundle members are rendered but not emitted on their own.
+	members := make(map[string]*parse.Tree)
+	for _, b := range cfg.bundles {
+		for _, m := range b.members {
+			members[m] = nil
+		}
+	}
+	rendered := make(map[string][]byte)
+
+	for _, tmpl := range renderClone.Templates() {
+		name := tmpl.Name()
+
+		k, ok := cfg.kindOf(name)
+		if !ok {
+			if cfg.nearMisses && isNearMiss(name) {
+				nearMisses = append(nearMisses, name)
+			}
+			continue
+		}
+
+		if !safeSuffix(strings.TrimPrefix(name, k.prefix)) {
+			if err := fail(fmt.Errorf("templatestatic: %q does not map to a file inside outputDir", name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		_, member := members[name]
+		if !member && cfg.streams(name) {
+			dir := outputDir
+			if d, ok := cfg.dests[k.kind]; ok {
+				dir = d.dir
+			}
+			tmp, err := renderToTemp(tmpl, data, dir, cfg)
+			if err != nil {
+				if err := fail(fmt.Errorf("templatestatic: rendering %q: %w", name, err)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			temps = append(temps, tmp.path)
+			if err := add(name, nil, tmp, tmpl.Tree); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			if err := fail(fmt.Errorf("templatestatic: rendering %q: %w", name, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if member {
+			rendered[name] = buf.Bytes()
+			members[name] = tmpl.Tree
+			continue
+		}
+		if err := add(name, buf.Bytes(), nil, tmpl.Tree); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range cfg.bundles {
+		raw, ok := joinMembers(b, rendered)
+		if !ok {
+			continue // a member failed to render; its error is in errs
+		}
+		if err := add(b.name, raw, nil, members[b.members[0]]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, errors.Join(errs...)
+	}
+
+	// Templates() is unordered; sort so tags and assets are deterministic.
+	sort.Slice(statics, func(i, j int) bool { return statics[i].name < statics[j].name })
+	sort.Strings(nearMisses)
+
+	if cfg.dedupe && cfg.fingerprint {
+		dedupe(statics, cfg)
+	}
+
+	if err := checkCollisions(statics, outputDir, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.strictNearMisses && len(nearMisses) > 0 {
+		return nil, fmt.Errorf("templatestatic: template names resemble static definitions: %s", strings.Join(nearMisses, ", "))
+	}
+
+	// Write files on a second clone (never Executed), or on t itself with
+	// WithInPlace. Extract needs none.
+	var resultClone *template.Template
+	switch {
+	case cfg.extractOnly:
+	case cfg.inPlace:
+		resultClone = t
+	default:
+		if resultClone, err = clone(t); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.keepSources && resultClone != nil {
+		if err := keepSources(resultClone, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.verify {
+		if err := verifyUnmodified(manifestPath(outputDir, cfg.manifest), statics); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.manifestOwner != "" {
+		if err := checkMergeConflicts(manifestPath(outputDir, cfg.manifest), cfg.manifestOwner, statics); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.embedded != nil {
+		if err := checkEmbedded(cfg.embedded, statics); err != nil {
+			return nil, err
+		}
+	}
+	compressed := make(map[string]map[string]int64) // file path -> sizes by encoding
+	written := make(map[string]bool)                // static name -> file written
+	for _, s := range statics {
+		if s.inline || s.shared || cfg.embedded != nil {
+			continue
+		}
+		path := filepath.Join(s.dir, filepath.FromSlash(s.filename))
+		if s.tmpFile != "" {
+			if written[s.name], err = renameIfChanged(s.tmpFile, path, s.hash); err != nil {
+				return nil, fmt.Errorf("templatestatic: writing %q to %s: %w", s.name, s.filename, err)
+			}
+			continue
+		}
+		if written[s.name], err = writeIfChanged(path, s.content); err != nil {
+			return nil, fmt.Errorf("templatestatic: writing %q to %s: %w", s.name, s.filename, err)
+		}
+		if s.sourceMap != nil {
+			if _, err := writeIfChanged(filepath.Join(s.dir, filepath.FromSlash(s.mapFile())), s.sourceMap); err != nil {
+				return nil, fmt.Errorf("templatestatic: writing source map of %q to %s: %w", s.name, s.mapFile(), err)
+			}
+		}
+		for _, c := range cfg.compressors {
+			z, err := c.fn(s.content)
+			if err != nil {
+				return nil, fmt.Errorf("templatestatic: compressing %q with %s: %w", s.name, c.encoding, err)
+			}
+			if _, err := writeIfChanged(path+c.ext, z); err != nil {
+				return nil, fmt.Errorf("templatestatic: writing %q to %s: %w", s.name, s.filename+c.ext, err)
+			}
+			if compressed[path] == nil {
+				compressed[path] = make(map[string]int64, len(cfg.compressors))
+			}
+			compressed[path][c.encoding] = int64(len(z))
+		}
+	}
+	// Stat after writing, so unchanged files report their original mtime.
+	for i := range statics {
+		s := &statics[i]
+		if s.inline {
+			continue
+		}
+		path := filepath.Join(s.dir, filepath.FromSlash(s.filename))
+		var fi fs.FileInfo
+		if cfg.embedded != nil {
+			fi, err = fs.Stat(cfg.embedded, s.filename)
+		} else {
+			fi, err = os.Stat(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("templatestatic: %q: %w", s.name, err)
+		}
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+		s.compressedSizes = compressed[path]
+	}
+
+	var placed map[string]bool
+	var injections int
+	if !cfg.noInject && resultClone != nil {
+		if placed, injections, err = rewrite(resultClone, statics, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.stripDefs && resultClone != nil {
+		if resultClone, err = stripStatics(resultClone, statics, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	urls := make(map[string]string, len(statics))
+	for _, s := range statics {
+		if !s.inline {
+			urls[s.logical] = s.url
+		}
+	}
+	if resultClone != nil {
+		resultClone.Funcs(template.FuncMap{
+			"asset":  assetFunc(urls),
+			inlineFn: inlineFunc(renderClone, statics, cfg),
+			rawFn:    func(i int) template.HTML { return template.HTML(cfg.rawHTML[i]) },
+		})
+		if !cfg.noInject {
+			tree, _ := parse.New(resultMarker).Parse("", "", "", make(map[string]*parse.Tree))
+			if _, err := resultClone.AddParseTree(resultMarker, tree); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	res := &Result{Template: resultClone, OutputDir: outputDir, NearMisses: nearMisses, Injections: injections, contents: make(map[string][]byte), crossOrigins: make(map[string]string)}
+	for _, s := range statics {
+		if !s.inline && s.tmpFile == "" {
+			res.contents[s.filename] = s.content
+		}
+		if !s.inline {
+			if v := cfg.crossOrigin(&s); v != "" {
+				res.crossOrigins[s.name] = v
+			}
+		}
+		var mapFile string
+		if s.sourceMap != nil {
+			mapFile = s.mapFile()
+			res.contents[mapFile] = s.sourceMap
+		}
+		res.Assets = append(res.Assets, AssetInfo{
+			Name:    s.name,
+			Kind:    s.kind,
+			Logical: s.logical,
+			Dir:     s.dir,
+			File:    s.filename,
+			URL:     s.url,
+			Hash:    s.hash,
+			ETag:    `"` + s.hash + `"`,
+			Placed:  placed[s.name],
+			ModTime: s.modTime,
+			Size:    s.size,
+
+			CompressedSizes: s.compressedSizes,
+
+			Content:   s.content,
+			Integrity: s.integrity,
+			SourceMap: mapFile,
+		})
+	}
+
+	for i, s := range statics {
+		switch {
+		case s.inline:
+		case written[s.name] && cfg.onWritten != nil:
+			cfg.onWritten(res.Assets[i])
+		case !written[s.name] && cfg.onSkipped != nil:
+			cfg.onSkipped(res.Assets[i])
+		}
+	}
+
+	if cfg.manifest != "" {
+		if err := writeManifest(manifestPath(outputDir, cfg.manifest), res.Assets, cfg.manifestOwner); err != nil {
+			return nil, fmt.Errorf("templatestatic: writing manifest %s: %w", cfg.manifest, err)
+		}
+	}
+	if cfg.checksums != "" {
+		if err := writeChecksums(manifestPath(outputDir, cfg.checksums), statics); err != nil {
+			return nil, fmt.Errorf("templatestatic: writing checksums %s: %w", cfg.checksums, err)
+		}
+	}
+	if cfg.goConstFile != "" {
+		if err := writeGoConstants(manifestPath(outputDir, cfg.goConstFile), cfg.goConstPkg, res.Assets); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.preview != nil {
+		if err := writePreview(cfg.preview, statics, placed); err != nil {
+			return nil, fmt.Errorf("templatestatic: preview: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// URLData is the data a urlPrefix is executed with under
+// WithURLPrefixTemplates.
+type URLData struct {
+	Name    string // template name, e.g. "static-css-main"
+	Logical string // logical file name, e.g. "main.css"
+	Kind    Kind
+	Hash    string // hex-encoded SHA-256 of the content
+}
+
+// renderURLPrefix executes the text/template text with data.
+func renderURLPrefix(text string, data URLData) (string, error) {
+	tmpl, err := texttemplate.New("urlPrefix").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("urlPrefix template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("urlPrefix template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// assetURL joins urlPrefix and the slash-separated filename. With
+// WithRelativeURLs, any leading slash is dropped so the URL resolves against
+// the page's base URL.
+func assetURL(urlPrefix, filename string, cfg *config) string {
+	prefix := strings.TrimSuffix(urlPrefix, "/")
+	if cfg.relativeURLs {
+		prefix = strings.TrimLeft(prefix, "/")
+		if prefix == "" {
+			return filename
+		}
+	}
+	return prefix + "/" + filename
+}
+
+// clone clones t, explaining the usual cause of failure.
+func clone(t *template.Template) (*template.Template, error) {
+	c, err := t.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("templatestatic: template has already been executed; pass it to Parse before its first Execute: %w", err)
+	}
+	return c, nil
+}
+
+// dedupe points each static whose content is identical to that of an earlier
+// static of the same kind at the earlier one's file, and marks it shared.
+// statics must be sorted, so the file kept is that of the first by name.
+func dedupe(statics []staticDef, cfg *config) {
+	first := make(map[string]*staticDef)
+	for i := range statics {
+		s := &statics[i]
+		if s.inline {
+			continue
+		}
+		key := string(s.kind) + ":" + s.hash
+		f, ok := first[key]
+		if !ok {
+			first[key] = s
+			continue
+		}
+		s.dir, s.filename, s.url, s.shared = f.dir, f.filename, f.url, true
+		// The tag was built successfully for the same URL and content.
+		s.tag, _ = cfg.tag(s)
+	}
+}
+
+// checkCollisions returns an error if two statics share a logical name or a
+// URL, or if two statics, their source maps, the manifest, or the checksums
+// file would be written to the same path. Names and paths are compared without
+// case so the result does not depend on the file system.
+func checkCollisions(statics []staticDef, outputDir string, cfg *config) error {
+	logicals := make(map[string]string, len(statics))
+	files := make(map[string]string, len(statics))
+	urls := make(map[string]string, len(statics))
+	fileKey := func(dir, file string) string {
+		return filepath.Clean(dir) + "\x00" + strings.ToLower(file)
+	}
+	for _, s := range statics {
+		if prev, ok := logicals[strings.ToLower(s.logical)]; ok {
+			return fmt.Errorf("templatestatic: %q and %q both write %q", prev, s.name, s.logical)
+		}
+		logicals[strings.ToLower(s.logical)] = s.name
+		if s.inline || s.shared {
+			continue
+		}
+		written := []string{s.filename}
+		if s.sourceMap != nil {
+			written = append(written, s.mapFile())
+		}
+		for _, c := range cfg.compressors {
+			written = append(written, s.filename+c.ext)
+		}
+		for _, file := range written {
+			k := fileKey(s.dir, file)
+			if prev, ok := files[k]; ok {
+				return fmt.Errorf("templatestatic: %q and %q both write %q", prev, s.name, file)
+			}
+			files[k] = s.name
+		}
+		if prev, ok := urls[s.url]; ok {
+			return fmt.Errorf("templatestatic: %q and %q both have URL %q", prev, s.name, s.url)
+		}
+		urls[s.url] = s.name
+	}
+	for _, f := range []struct{ what, name string }{
+		{"manifest", cfg.manifest},
+		{"checksums file", cfg.checksums},
+	} {
+		if f.name == "" || filepath.IsAbs(f.name) {
+			continue
+		}
+		if prev, ok := files[fileKey(outputDir, filepath.ToSlash(filepath.Clean(f.name)))]; ok {
+			return fmt.Errorf("templatestatic: %s %q would overwrite the output of %q", f.what, f.name, prev)
+		}
+	}
+	return nil
+}
+
+// rewrite redefines each static in t to its tag or to nothing, and injects
+// the tags of those without an explicit {{template}} call. It returns the set
+// of explicitly placed names and the number of injection points spliced.
+func rewrite(t *template.Template, statics []staticDef, cfg *config) (map[string]bool, int, error) {
+	// Bundles have no definition in t; give them an empty one so they can be
+	// placed and redefined like any other static. Their members render
+	// nothing.
+	for _, s := range statics {
+		if t.Lookup(s.name) == nil {
+			tree, _ := parse.New(s.name).Parse("", "", "", make(map[string]*parse.Tree))
+			t.AddParseTree(s.name, tree)
+		}
+	}
+	for _, b := range cfg.bundles {
+		for _, m := range b.members {
+			setText(t, m, "")
+		}
+	}
+
+	// Calls inside a {{range}} body would emit the tag once per iteration;
+	// drop them so those statics fall back to a single auto-injection. Inline
+	// statics are meant to render per call and keep theirs.
+	dropLoopCalls(t, cfg)
+	if cfg.placeOnce {
+		dropRepeatCalls(t, cfg)
+	}
 
-	// Collect static definitions and their rendered content.
-	type staticDef struct {
-		name, filename, tag string
-		content             []byte
-		isCSS               bool
+	// Find which static names have explicit {{template "static-*"}} calls.
+	placed := findPlacedTemplates(t, cfg)
+
+	var auto []staticDef
+	for _, s := range statics {
+		if placed[s.name] && s.inline && !isBundle(cfg, s.name) {
+			// Inline content is rendered again per call, with the caller's dot.
+			setInlineCall(t, s.name)
+		} else if placed[s.name] {
+			// Explicit call exists — redefine to output the tag there.
+			setText(t, s.name, cfg.tagText(s))
+		} else {
+			// No explicit call — redefine to empty, collect for auto-injection.
+			setText(t, s.name, "")
+			auto = append(auto, s)
+		}
 	}
-	var statics []staticDef
 
-	for _, tmpl := range renderClone.Templates() {
-		name := tmpl.Name()
+	// Inject auto tags in order, by default before </head>; those placed
+	// with AtBodyEnd go before </body>.
+	orderAuto(auto, cfg)
+	var headTags, bodyTags []string
+	if cfg.buildComment != "" {
+		headTags = append(headTags, cfg.raw("<!-- "+cfg.buildComment+" -->"))
+	}
+	for _, html := range cfg.headTags {
+		if !containsTextFold(t, html) {
+			headTags = append(headTags, cfg.raw(html))
+		}
+	}
+	for _, s := range auto {
+		if cfg.def(s.name).placement == AtBodyEnd {
+			bodyTags = append(bodyTags, cfg.tagText(s))
+		} else {
+			headTags = append(headTags, cfg.tagText(s))
+		}
+	}
+	var injections int
+	for _, g := range []struct {
+		tags   []string
+		splice splicer
+		where  string
+	}{
+		{headTags, cfg.splicer(), "head injection point"},
+		{bodyTags, cfg.beforeClose("</body>"), "</body>"},
+	} {
+		if len(g.tags) == 0 {
+			continue
+		}
+		if inject(t, g.tags, g.splice, cfg.injectInto) {
+			injections++
+		} else if cfg.strictInject {
+			return nil, 0, fmt.Errorf("templatestatic: no template contains a %s for %d auto-injected tags", g.where, len(g.tags))
+		}
+	}
+	if len(cfg.rawHTML) > 0 {
+		expandRaw(t)
+	}
+	return placed, injections, nil
+}
 
-		var suffix, ext, tag string
-		var isCSS bool
-		switch {
-		case strings.HasPrefix(name, "static-css-"):
-			suffix = strings.TrimPrefix(name, "static-css-")
-			ext = ".css"
-			tag = `<link rel="stylesheet" href="` + urlPrefix + "/" + suffix + ext + `">`
-			isCSS = true
-		case strings.HasPrefix(name, "static-js-"):
-			suffix = strings.TrimPrefix(name, "static-js-")
-			ext = ".js"
-			tag = `<script src="` + urlPrefix + "/" + suffix + ext + `"></script>`
-		default:
+// containsTextFold reports whether the text of a template associated with t
+// contains s, ignoring case.
+func containsTextFold(t *template.Template, s string) bool {
+	found := false
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil || found {
 			continue
 		}
+		walkTree(tmpl.Tree.Root, func(n parse.Node) {
+			if tn, ok := n.(*parse.TextNode); ok && indexFold(tn.Text, s) >= 0 {
+				found = true
+			}
+		})
+	}
+	return found
+}
 
-		var buf bytes.Buffer
-		if err := tmpl.Execute(&buf, data); err != nil {
-			return nil, err
+// orderAuto sorts auto-injected statics: first those named by WithOrder, in
+// that order, then the rest as chosen by WithTagOrder.
+func orderAuto(auto []staticDef, cfg *config) {
+	rank := func(s staticDef) int {
+		if i := slices.Index(cfg.order, s.name); i >= 0 {
+			return i
+		}
+		k := kindIndex(s.kind)
+		switch cfg.tagOrder {
+		case JSFirst:
+			// Swap stylesheets and scripts; other kinds keep their place.
+			css, js := kindIndex(KindCSS), kindIndex(KindJS)
+			switch s.kind {
+			case KindCSS:
+				k = js
+			case KindJS:
+				k = css
+			}
+		case SourceOrder:
+			k = 0
+		}
+		return len(cfg.order) + k
+	}
+	sort.SliceStable(auto, func(i, j int) bool {
+		a, b := auto[i], auto[j]
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		if cfg.tagOrder != SourceOrder {
+			return false
+		}
+		if a.parseName != b.parseName {
+			return a.parseName < b.parseName
 		}
+		return a.pos < b.pos
+	})
+}
 
-		statics = append(statics, staticDef{
-			name:     name,
-			filename: suffix + ext,
-			tag:      tag,
-			content:  buf.Bytes(),
-			isCSS:    isCSS,
-		})
+// FuncMap returns placeholders for the funcs Parse registers on its result,
+// so templates that call them can be parsed beforehand:
+//
+//	t := template.Must(template.New("").Funcs(templatestatic.FuncMap()).ParseGlob("*.html"))
+//
+// The result of Parse replaces them with working versions:
+//
+//   - asset returns the URL of a generated asset given its logical name, as
+//     in <link rel="preload" href="{{asset "main.css"}}" as="style">.
+//     Unknown names are an execution error.
+//
+// The placeholders return an error if executed. The result of Parse always
+// defines these names, replacing any func of the same name in t.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"asset": func(string) (string, error) {
+			return "", errors.New("templatestatic: asset called on a template not returned by Parse")
+		},
 	}
+}
 
-	// Write files on a second clone (never Executed).
-	resultClone, err := t.Clone()
-	if err != nil {
-		return nil, err
+func assetFunc(urls map[string]string) func(string) (string, error) {
+	return func(name string) (string, error) {
+		url, ok := urls[name]
+		if !ok {
+			return "", fmt.Errorf("templatestatic: no asset named %q", name)
+		}
+		return url, nil
 	}
+}
 
-	// Find which static names have explicit {{template "static-*"}} calls.
-	placed := findPlacedTemplates(resultClone)
+// setText replaces the body of the named template in t with literal text.
+// The text is never parsed, so it may safely contain action delimiters. It
+// mutates t's parse trees in place, so callers pass either a clone, whose
+// trees html/template's Clone copied, or, under WithInPlace, the template the
+// caller handed to Parse.
+//
+// Redefining with {{define}} is not an option here because text/template
+// ignores an empty redefinition of an existing template.
+func setText(t *template.Template, name, text string) {
+	root := t.Lookup(name).Tree.Root
+	root.Nodes = nil
+	if text != "" {
+		tree, _ := parse.New(name).Parse("-", "", "", make(map[string]*parse.Tree))
+		n := tree.Root.Nodes[0].(*parse.TextNode)
+		n.Text = []byte(text)
+		root.Nodes = append(root.Nodes, n)
+	}
+}
 
-	var redefs []string
-	var autoCSS, autoJS []string
-	for _, s := range statics {
-		if err := writeIfChanged(filepath.Join(outputDir, s.filename), s.content); err != nil {
-			return nil, err
+// rawFn is the func that emits tags containing HTML comments, such as the
+// WithBuildComment comment or a WithConditionalComment tag: html/template
+// strips comments written as template text, but not those in a
+// template.HTML value. Until expandRaw swaps in a {{rawFn N}} call, such a
+// tag is held in the text by a mark from config.raw.
+const rawFn = "templatestaticRaw"
+
+// rawMark matches the marks config.raw returns.
+var rawMark = regexp.MustCompile("\x00templatestatic:([0-9]+)\x00")
+
+// raw returns html, or, if it contains a comment, a mark standing for it.
+func (c *config) raw(html string) string {
+	if !strings.Contains(html, "<!--") {
+		return html
+	}
+	c.rawHTML = append(c.rawHTML, html)
+	return "\x00templatestatic:" + strconv.Itoa(len(c.rawHTML)-1) + "\x00"
+}
+
+// tagText returns the template text that emits the tag of s. Inline tags
+// stay template text, so html/template treats their content as before.
+func (c *config) tagText(s staticDef) string {
+	if s.inline {
+		return s.tag
+	}
+	return c.raw(s.tag)
+}
+
+// expandRaw replaces each mark from config.raw in the text of every template
+// in t with a {{rawFn N}} call.
+func expandRaw(t *template.Template) {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			expandRawInList(tmpl.Tree.Root)
 		}
-		if placed[s.name] {
-			// Explicit call exists — redefine to output the tag there.
-			redefs = append(redefs, `{{define "`+s.name+`"}}`+s.tag+`{{end}}`)
-		} else {
-			// No explicit call — redefine to empty, collect for auto-injection.
-			redefs = append(redefs, `{{define "`+s.name+`"}}{{end}}`)
-			if s.isCSS {
-				autoCSS = append(autoCSS, s.tag)
-			} else {
-				autoJS = append(autoJS, s.tag)
+	}
+}
+
+func expandRawInList(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+	var nodes []parse.Node
+	for _, n := range list.Nodes {
+		tn, ok := n.(*parse.TextNode)
+		if !ok {
+			if b := branch(n); b != nil {
+				expandRawInList(b.List)
+				expandRawInList(b.ElseList)
 			}
+			nodes = append(nodes, n)
+			continue
+		}
+		marks := rawMark.FindAllSubmatchIndex(tn.Text, -1)
+		if marks == nil {
+			nodes = append(nodes, n)
+			continue
+		}
+		stub := map[string]any{rawFn: func(int) template.HTML { return "" }}
+		off := 0
+		for _, m := range marks {
+			call, _ := parse.New("").Parse(`{{`+rawFn+` `+string(tn.Text[m[2]:m[3]])+`}}`, "", "", make(map[string]*parse.Tree), stub)
+			nodes = append(nodes, &parse.TextNode{NodeType: parse.NodeText, Pos: tn.Pos, Text: tn.Text[off:m[0]]}, call.Root.Nodes[0])
+			off = m[1]
 		}
+		nodes = append(nodes, &parse.TextNode{NodeType: parse.NodeText, Pos: tn.Pos, Text: tn.Text[off:]})
 	}
+	list.Nodes = nodes
+}
 
-	if len(redefs) > 0 {
-		if _, err := resultClone.Parse(strings.Join(redefs, "")); err != nil {
-			return nil, err
+// inlineFn is the func that placed inline statics are redefined to call.
+const inlineFn = "templatestaticInline"
+
+// setInlineCall redefines the named template in t to {{inlineFn "name" .}}.
+func setInlineCall(t *template.Template, name string) {
+	stub := map[string]any{inlineFn: func(string, any) (template.HTML, error) { return "", nil }}
+	tree, _ := parse.New(name).Parse(`{{`+inlineFn+` `+strconv.Quote(name)+` .}}`, "", "", make(map[string]*parse.Tree), stub)
+	t.Lookup(name).Tree.Root.Nodes = tree.Root.Nodes
+}
+
+// inlineFunc returns the func behind placed inline statics: it renders the
+// named static's definition in src with the caller's dot, runs it through the
+// pipeline, and returns the inline tag.
+func inlineFunc(src *template.Template, statics []staticDef, cfg *config) func(string, any) (template.HTML, error) {
+	inline := make(map[string]staticDef)
+	for _, s := range statics {
+		if s.inline {
+			inline[s.name] = s
+		}
+	}
+	return func(name string, dot any) (template.HTML, error) {
+		s, ok := inline[name]
+		if !ok {
+			return "", fmt.Errorf("templatestatic: %q is not an inline static", name)
+		}
+		var buf bytes.Buffer
+		if err := src.ExecuteTemplate(&buf, name, dot); err != nil {
+			return "", fmt.Errorf("templatestatic: rendering %q: %w", name, err)
+		}
+		content, _, err := process(name, s.kind, s.logical, buf.Bytes(), cfg)
+		if err != nil {
+			return "", fmt.Errorf("templatestatic: %q: %w", name, err)
+		}
+		tag, err := inlineTag(s.kind, content)
+		if err != nil {
+			return "", fmt.Errorf("templatestatic: %q: %w", name, err)
+		}
+		return template.HTML(tag), nil
+	}
+}
+
+// stripStatics returns a copy of t without the definitions of statics and
+// bundle members that no remaining {{template}} call refers to. html/template
+// cannot delete templates, so the set is rebuilt from the remaining parse
+// trees, with cfg.stripFuncs as its funcs.
+func stripStatics(t *template.Template, statics []staticDef, cfg *config) (*template.Template, error) {
+	called := findPlacedTemplates(t, cfg)
+	drop := make(map[string]bool)
+	for _, s := range statics {
+		drop[s.name] = !called[s.name]
+	}
+	for _, b := range cfg.bundles {
+		for _, m := range b.members {
+			drop[m] = !called[m]
+		}
+	}
+
+	nt := template.New(t.Name()).Funcs(cfg.stripFuncs)
+	for _, tmpl := range sortedTemplates(t) {
+		if drop[tmpl.Name()] || tmpl.Tree == nil {
+			continue
+		}
+		if _, err := nt.AddParseTree(tmpl.Name(), tmpl.Tree); err != nil {
+			return nil, fmt.Errorf("templatestatic: %q: %w", tmpl.Name(), err)
 		}
 	}
+	return nt, nil
+}
 
-	// Inject auto tags before </head> (CSS first, then JS).
-	autoTags := append(autoCSS, autoJS...)
-	if len(autoTags) > 0 {
-		injectBeforeCloseHead(resultClone, autoTags)
+// resultMarker names an empty template added to every result whose statics
+// are redefined to their tags, so passing one back to Parse is an error
+// rather than a confusing second build. WithoutInjection leaves the statics
+// alone and adds no marker.
+const resultMarker = "templatestatic-result"
+
+// sourceSuffix is appended to a static's name for the copy of its original
+// definition kept by WithSourceDefinitions.
+const sourceSuffix = "-source"
+
+// keepSources adds a copy of each static definition in t under its name plus
+// sourceSuffix.
+func keepSources(t *template.Template, cfg *config) error {
+	for _, tmpl := range sortedTemplates(t) {
+		name := tmpl.Name()
+		if _, ok := cfg.kindOf(name); !ok || tmpl.Tree == nil {
+			continue
+		}
+		if t.Lookup(name+sourceSuffix) != nil {
+			return fmt.Errorf("templatestatic: %q already defined; cannot keep the source of %q", name+sourceSuffix, name)
+		}
+		if _, err := t.AddParseTree(name+sourceSuffix, tmpl.Tree.Copy()); err != nil {
+			return fmt.Errorf("templatestatic: %q: %w", name, err)
+		}
 	}
+	return nil
+}
 
-	return resultClone, nil
+// FindPlacedStatics returns the sorted names of the statics that some
+// template associated with t places with an explicit {{template}} call,
+// whether or not they are defined, e.g. to lint a template set for statics
+// that rely on auto-injection. Calls inside a {{range}} body are included,
+// although Parse auto-injects those statics instead. Of opts, only WithKinds
+// applies. t is not modified.
+func FindPlacedStatics(t *template.Template, opts ...Option) []string {
+	return slices.Sorted(maps.Keys(findPlacedTemplates(t, newConfig(opts))))
 }
 
 // findPlacedTemplates walks all templates in t and returns a set of names
 // that are explicitly invoked via {{template "name"}} calls.
-func findPlacedTemplates(t *template.Template) map[string]bool {
+func findPlacedTemplates(t *template.Template, cfg *config) map[string]bool {
 	placed := make(map[string]bool)
 	for _, tmpl := range t.Templates() {
 		if tmpl.Tree == nil {
@@ -119,7 +1250,7 @@ func findPlacedTemplates(t *template.Template) map[string]bool {
 		}
 		walkTree(tmpl.Tree.Root, func(n parse.Node) {
 			if tn, ok := n.(*parse.TemplateNode); ok {
-				if strings.HasPrefix(tn.Name, "static-css-") || strings.HasPrefix(tn.Name, "static-js-") {
+				if _, ok := cfg.kindOf(tn.Name); ok {
 					placed[tn.Name] = true
 				}
 			}
@@ -128,6 +1259,87 @@ func findPlacedTemplates(t *template.Template) map[string]bool {
 	return placed
 }
 
+// undefinedCalls returns an error for each {{template "static-*"}} call in t
+// whose static has no definition and is not a bundle, sorted so the first is
+// deterministic. Such a call would otherwise fail only when executed.
+func undefinedCalls(t *template.Template, cfg *config) []error {
+	var errs []error
+	for _, tmpl := range sortedTemplates(t) {
+		if tmpl.Tree == nil {
+			continue
+		}
+		walkTree(tmpl.Tree.Root, func(n parse.Node) {
+			tn, ok := n.(*parse.TemplateNode)
+			if !ok {
+				return
+			}
+			if _, ok := cfg.kindOf(tn.Name); !ok || isBundle(cfg, tn.Name) {
+				return
+			}
+			if d := t.Lookup(tn.Name); d == nil || d.Tree == nil {
+				errs = append(errs, fmt.Errorf("templatestatic: %q calls {{template %q}}, which is not defined", tmpl.Name(), tn.Name))
+			}
+		})
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+// dropLoopCalls removes every {{template "static-*"}} call in t that appears,
+// directly or nested in {{if}}/{{with}}, inside the body of a {{range}}. The
+// {{else}} branch of a range runs at most once and is left alone, and so are
+// calls of inline statics other than bundles, which render with each
+// iteration's dot.
+func dropLoopCalls(t *template.Template, cfg *config) {
+	dropCalls(t, cfg, func(name string, inLoop bool) bool {
+		return inLoop && (!cfg.def(name).inline || isBundle(cfg, name))
+	})
+}
+
+// dropRepeatCalls keeps only the first {{template "static-*"}} call for each
+// static, in template-name order and then source order within a template.
+func dropRepeatCalls(t *template.Template, cfg *config) {
+	seen := make(map[string]bool)
+	dropCalls(t, cfg, func(name string, _ bool) bool {
+		if seen[name] {
+			return true
+		}
+		seen[name] = true
+		return false
+	})
+}
+
+// dropCalls removes the static calls in t for which drop reports true. drop
+// is called in a deterministic order and told whether the call is inside a
+// {{range}} body.
+func dropCalls(t *template.Template, cfg *config, drop func(name string, inLoop bool) bool) {
+	for _, tmpl := range sortedTemplates(t) {
+		if tmpl.Tree != nil {
+			dropCallsInList(tmpl.Tree.Root, false, cfg, drop)
+		}
+	}
+}
+
+func dropCallsInList(list *parse.ListNode, inLoop bool, cfg *config, drop func(string, bool) bool) {
+	if list == nil {
+		return
+	}
+	nodes := list.Nodes[:0]
+	for _, n := range list.Nodes {
+		if tn, ok := n.(*parse.TemplateNode); ok {
+			if _, ok := cfg.kindOf(tn.Name); ok && drop(tn.Name, inLoop) {
+				continue
+			}
+		} else if b := branch(n); b != nil {
+			_, isRange := n.(*parse.RangeNode)
+			dropCallsInList(b.List, inLoop || isRange, cfg, drop)
+			dropCallsInList(b.ElseList, inLoop, cfg, drop)
+		}
+		nodes = append(nodes, n)
+	}
+	list.Nodes = nodes
+}
+
 // walkTree recursively visits every node in the parse tree.
 func walkTree(n parse.Node, fn func(parse.Node)) {
 	if n == nil {
@@ -142,76 +1354,341 @@ func walkTree(n parse.Node, fn func(parse.Node)) {
 		for _, child := range n.Nodes {
 			walkTree(child, fn)
 		}
+	default:
+		if b := branch(n); b != nil {
+			walkTree(b.List, fn)
+			walkTree(b.ElseList, fn)
+		}
+	}
+}
+
+// branch returns the branches of an {{if}}, {{range}}, or {{with}} node, or
+// nil for any other node. These are the only nodes with nested lists; an
+// {{else if}} or {{else with}} chain is an ElseList holding a single nested
+// IfNode or WithNode.
+func branch(n parse.Node) *parse.BranchNode {
+	switch n := n.(type) {
 	case *parse.IfNode:
-		walkTree(n.List, fn)
-		walkTree(n.ElseList, fn)
+		return &n.BranchNode
 	case *parse.RangeNode:
-		walkTree(n.List, fn)
-		walkTree(n.ElseList, fn)
+		return &n.BranchNode
 	case *parse.WithNode:
-		walkTree(n.List, fn)
-		walkTree(n.ElseList, fn)
+		return &n.BranchNode
 	}
+	return nil
 }
 
-// injectBeforeCloseHead finds the first </head> in any text node across
-// all templates and splices formatted tags before it.
-func injectBeforeCloseHead(t *template.Template, tags []string) {
-	for _, tmpl := range t.Templates() {
-		if tmpl.Tree == nil {
-			continue
-		}
-		if injectInList(tmpl.Tree.Root, tags) {
-			return
-		}
-	}
+// safeSuffix reports whether suffix, the part of a static name after its
+// prefix, maps to a file inside the output directory. Slash-separated nested
+// names like "themes/dark" are allowed; empty, absolute, backslashed, and ".."
+// components are not.
+func safeSuffix(suffix string) bool {
+	return suffix != "" && suffix != "." && !strings.Contains(suffix, `\`) &&
+		fs.ValidPath(suffix) && filepath.IsLocal(filepath.FromSlash(suffix))
 }
 
-func injectInList(list *parse.ListNode, tags []string) bool {
-	if list == nil {
-		return false
+// renderFilename executes the filename template text with data and checks
+// that the result, the file's path without extension, stays inside outputDir.
+func renderFilename(text string, data any) (string, error) {
+	tmpl, err := texttemplate.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("filename template: %w", err)
 	}
-	for _, n := range list.Nodes {
-		switch n := n.(type) {
-		case *parse.TextNode:
-			i := bytes.Index(n.Text, []byte("</head>"))
-			if i >= 0 {
-				var injection []byte
-				if i == 0 || n.Text[i-1] != '\n' {
-					injection = append(injection, '\n')
-				}
-				for _, tag := range tags {
-					injection = append(injection, ("  " + tag + "\n")...)
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("filename template: %w", err)
+	}
+	if !safeSuffix(b.String()) {
+		return "", fmt.Errorf("filename %q does not map to a file inside outputDir", b.String())
+	}
+	return b.String(), nil
+}
+
+// apply returns name converted to fc. Word boundaries are only found at ASCII
+// capitals.
+func (fc FilenameCase) apply(name string) string {
+	switch fc {
+	case LowerCase:
+		return strings.ToLower(name)
+	case KebabCase:
+		var b strings.Builder
+		for i := 0; i < len(name); i++ {
+			c := name[i]
+			if isUpper(c) && i > 0 {
+				prev := name[i-1]
+				// A hyphen starts each word: after a lower-case letter or
+				// digit, or before the last capital of an acronym that is
+				// followed by a lower-case letter.
+				if isLower(prev) || isDigit(prev) || isUpper(prev) && i+1 < len(name) && isLower(name[i+1]) {
+					b.WriteByte('-')
 				}
-				n.Text = append(n.Text[:i], append(injection, n.Text[i:]...)...)
-				return true
-			}
-		case *parse.IfNode:
-			if injectInList(n.List, tags) || injectInList(n.ElseList, tags) {
-				return true
 			}
-		case *parse.RangeNode:
-			if injectInList(n.List, tags) || injectInList(n.ElseList, tags) {
-				return true
-			}
-		case *parse.WithNode:
-			if injectInList(n.List, tags) || injectInList(n.ElseList, tags) {
-				return true
+			b.WriteByte(c)
+		}
+		return strings.ToLower(b.String())
+	}
+	return name
+}
+
+func isUpper(c byte) bool { return 'A' <= c && c <= 'Z' }
+func isLower(c byte) bool { return 'a' <= c && c <= 'z' }
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// fingerprintPattern matches filenames produced by fingerprintName with a
+// fingerprint of at least 8 hex digits.
+var fingerprintPattern = regexp.MustCompile(`\.[0-9a-f]{8,}\.[^./]+$`)
+
+// validFingerprint matches what a WithFingerprintHash func may return.
+var validFingerprint = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// fingerprintName inserts fp before the extension of filename: with fp
+// "1a2b3c4d", "themes/dark.css" becomes "themes/dark.1a2b3c4d.css".
+func fingerprintName(filename, fp string) string {
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + fp + ext
+}
+
+// isFingerprinted reports whether filename looks like a fingerprinted name.
+func isFingerprinted(filename string) bool {
+	return fingerprintPattern.MatchString(filename)
+}
+
+// checkOutputFile returns an error if filename, given to the option opt and
+// resolved as by manifestPath, is relative but not inside outputDir, or lies
+// outside cfg.allowedRoot.
+func checkOutputFile(opt, outputDir, filename string, cfg *config) error {
+	if !filepath.IsAbs(filename) && !filepath.IsLocal(filename) {
+		return fmt.Errorf("templatestatic: %s: %q is not inside outputDir", opt, filename)
+	}
+	if cfg.allowedRoot == "" {
+		return nil
+	}
+	return checkWithinRoot(cfg.allowedRoot, opt+" file", manifestPath(outputDir, filename))
+}
+
+// checkWithinRoot returns an error, describing dir as what, if dir does not
+// resolve to root or a path beneath it.
+func checkWithinRoot(root, what, dir string) error {
+	resolvedRoot, err := resolvePath(root)
+	if err != nil {
+		return err
+	}
+	resolvedDir, err := resolvePath(dir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolvedDir)
+	if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+		return fmt.Errorf("templatestatic: %s %q is outside allowed root %q", what, dir, root)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute form of p with symlinks resolved. Trailing
+// components that do not exist yet are kept as given.
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		parent := filepath.Dir(p)
+		if !errors.Is(err, fs.ErrNotExist) || parent == p {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(p)}, missing...)
+		p = parent
+	}
+}
+
+// kindInfo describes one kind of static definition.
+type kindInfo struct {
+	kind   Kind
+	prefix string // template name prefix
+	ext    string // default file extension
+}
+
+var kinds = []kindInfo{
+	{KindIcon, "static-icon-", ".ico"},
+	{KindManifest, "static-manifest-", ".webmanifest"},
+	{KindCSS, "static-css-", ".css"},
+	{KindJS, "static-js-", ".js"},
+}
+
+// kindOf returns the kind of the static named name: the one WithKinds gives
+// it, with an empty prefix, or else the one its prefix selects.
+func (c *config) kindOf(name string) (kindInfo, bool) {
+	if kind, ok := c.forcedKinds[name]; ok {
+		for _, k := range kinds {
+			if k.kind == kind {
+				return kindInfo{kind, "", k.ext}, true
 			}
 		}
 	}
+	return lookupKind(name)
+}
+
+// kindIndex returns the position of kind in kinds, which is also its place
+// among auto-injected tags.
+func kindIndex(kind Kind) int {
+	return slices.IndexFunc(kinds, func(k kindInfo) bool { return k.kind == kind })
+}
+
+// lookupKind returns the kind whose prefix name starts with.
+func lookupKind(name string) (kindInfo, bool) {
+	for _, k := range kinds {
+		if strings.HasPrefix(name, k.prefix) {
+			return k, true
+		}
+	}
+	return kindInfo{}, false
+}
+
+// isNearMiss reports whether name, which matches no static prefix, starts
+// with something within a small edit distance of one, ignoring case.
+func isNearMiss(name string) bool {
+	name = strings.ToLower(name)
+	for _, k := range kinds {
+		if prefixDistance(k.prefix, name) <= 2 {
+			return true
+		}
+	}
 	return false
 }
 
+// prefixDistance returns the smallest Levenshtein distance between p and any
+// prefix of s.
+func prefixDistance(p, s string) int {
+	prev := make([]int, len(s)+1)
+	cur := make([]int, len(s)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(p); i++ {
+		cur[0] = i
+		for j := 1; j <= len(s); j++ {
+			cost := 1
+			if p[i-1] == s[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return slices.Min(prev)
+}
+
 // writeIfChanged writes content to path only if the file doesn't exist or its
-// content differs. This preserves mtime for stable caching.
-func writeIfChanged(path string, content []byte) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return err
+// content differs, and reports whether it wrote. This preserves mtime for
+// stable caching.
+//
+// The new content is written to a temporary file and renamed into place, so
+// concurrent readers, such as a FileServer serving a previous result, see
+// either the old file or the new one, never a partial write.
+func writeIfChanged(path string, content []byte) (bool, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return false, err
 	}
 	existing, err := os.ReadFile(path)
 	if err == nil && bytes.Equal(existing, content) {
-		return nil
+		return false, nil
+	}
+
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return false, err
+	}
+	return true, nil
+}
+
+// A tempAsset is content streamed to a temporary file, with its hex SHA-256
+// and integrity value.
+type tempAsset struct {
+	path, hash, integrity string
+}
+
+// renderToTemp executes tmpl with data straight into a new temporary file in
+// dir, hashing as it goes, so the content is never held in memory.
+func renderToTemp(tmpl *template.Template, data any, dir string, cfg *config) (*tempAsset, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".templatestatic-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	sri, prefix := cfg.integrityHasher()
+	w := bufio.NewWriter(io.MultiWriter(f, sum, sri))
+	err = tmpl.Execute(w, data)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &tempAsset{
+		path:      f.Name(),
+		hash:      hex.EncodeToString(sum.Sum(nil)),
+		integrity: prefix + base64.StdEncoding.EncodeToString(sri.Sum(nil)),
+	}, nil
+}
+
+// renameIfChanged is writeIfChanged for content already in the file tmp, whose
+// hex SHA-256 is hash: it renames tmp to path unless path already holds the
+// same content, in which case tmp is removed. It reports whether it renamed.
+// The existing file is hashed as a stream rather than read into memory.
+func renameIfChanged(tmp, path, hash string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if ti, err := os.Stat(tmp); err == nil && ti.Size() == fi.Size() {
+			if existing, err := fileHash(path); err == nil && existing == hash {
+				return false, os.Remove(tmp)
+			}
+		}
+	}
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+// fileHash returns the hex SHA-256 of the file at path.
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
 	}
-	return os.WriteFile(path, content, 0o644)
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -2,10 +2,20 @@ package templatestatic
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Auto-injection: no explicit {{template}} calls, tags injected before </head>.
@@ -181,18 +191,52 @@ func TestParseWithData(t *testing.T) {
 	}
 }
 
+func TestParseNoStatics(t *testing.T) {
+	const tmplStr = `{{define "nav"}}<nav>{{.}}</nav>{{end}}
+<html>
+<head><title>T</title></head>
+<body>{{template "nav" .}}</body>
+</html>`
+	tmpl := template.Must(template.New("page").Parse(tmplStr))
+	outDir := filepath.Join(t.TempDir(), "static")
+	rt, err := Parse(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	res, err := Build(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(res.Assets) != 0 || res.Injections != 0 {
+		t.Errorf("Assets = %v, Injections = %d; want none", res.Assets, res.Injections)
+	}
+	var got, want bytes.Buffer
+	if err := rt.Execute(&got, "home"); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if err := tmpl.Execute(&want, "home"); err != nil {
+		t.Fatalf("Execute original: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("output:\n%s\nwant the original render:\n%s", got.String(), want.String())
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Errorf("outputDir created with no statics to write: %v", err)
+	}
+}
+
 func TestWriteIfChangedPreservesMtime(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "test.css")
 
 	content := []byte("body{}")
-	if err := writeIfChanged(path, content); err != nil {
+	if _, err := writeIfChanged(path, content); err != nil {
 		t.Fatal(err)
 	}
 	info1, _ := os.Stat(path)
 
 	// Write same content again — mtime should not change.
-	if err := writeIfChanged(path, content); err != nil {
+	if _, err := writeIfChanged(path, content); err != nil {
 		t.Fatal(err)
 	}
 	info2, _ := os.Stat(path)
@@ -202,7 +246,7 @@ func TestWriteIfChangedPreservesMtime(t *testing.T) {
 	}
 
 	// Write different content — mtime should change.
-	if err := writeIfChanged(path, []byte("div{}")); err != nil {
+	if _, err := writeIfChanged(path, []byte("div{}")); err != nil {
 		t.Fatal(err)
 	}
 	got, _ := os.ReadFile(path)
@@ -210,3 +254,1327 @@ func TestWriteIfChangedPreservesMtime(t *testing.T) {
 		t.Errorf("content = %q, want %q", got, "div{}")
 	}
 }
+
+func TestParseRejectsUnsafeNames(t *testing.T) {
+	for _, name := range []string{
+		"static-css-../../etc/passwd",
+		"static-css-/etc/passwd",
+		"static-js-a/../../b",
+		`static-js-..\evil`,
+		"static-css-",
+	} {
+		tmpl := template.Must(template.New("test").Parse(`{{define ` + strconv.Quote(name) + `}}x{{end}}`))
+		outDir := t.TempDir()
+		if _, err := Parse(tmpl, nil, outDir, "/static"); err == nil {
+			t.Errorf("Parse with %q: expected error", name)
+		}
+	}
+}
+
+func TestParseNestedName(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(`{{define "static-css-themes/dark"}}x{{end}}`))
+	outDir := t.TempDir()
+	if _, err := Parse(tmpl, nil, outDir, "/static"); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "themes", "dark.css")); err != nil {
+		t.Errorf("nested file not written: %v", err)
+	}
+}
+
+// Disk paths use the OS separator, but files, URLs, and tags always use
+// forward slashes, whatever filepath.Join produces.
+func TestNestedNamesForwardSlashURLs(t *testing.T) {
+	const src = `{{define "static-css-a/b/c"}}x{{end}}
+{{define "static-js-app"}}y{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(src))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, "2", outDir, "/static/", WithFilenameTemplate("static-js-app", "v{{.}}/app"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := map[string]string{"static-css-a/b/c": "/static/a/b/c.css", "static-js-app": "/static/v2/app.js"}
+	for _, a := range res.Assets {
+		if a.URL != want[a.Name] {
+			t.Errorf("%s: URL = %q, want %q", a.Name, a.URL, want[a.Name])
+		}
+		if strings.Contains(a.File, `\`) {
+			t.Errorf("%s: File = %q contains a backslash", a.Name, a.File)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(a.File))); err != nil {
+			t.Errorf("%s: %v", a.Name, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if strings.Contains(buf.String(), `\`) {
+		t.Errorf("tags contain a backslash: %s", buf.String())
+	}
+}
+
+// ModTime is the file's mtime after the write, and an unchanged rebuild keeps
+// it.
+func TestBuildModTime(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, a := range res.Assets {
+		path := filepath.Join(outDir, a.File)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.ModTime.IsZero() || !a.ModTime.Equal(info.ModTime()) {
+			t.Errorf("%s: ModTime = %v, want %v", a.Name, a.ModTime, info.ModTime())
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err = Build(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		if !a.ModTime.Equal(old) {
+			t.Errorf("%s: ModTime = %v after unchanged rebuild, want %v", a.Name, a.ModTime, old)
+		}
+	}
+}
+
+func TestWithStreamingWrites(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	buffered, err := Build(tmpl, nil, t.TempDir(), "/static", WithFingerprint())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithFingerprint(), WithStreamingWrites())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i, a := range res.Assets {
+		b := buffered.Assets[i]
+		if a.File != b.File || a.Hash != b.Hash || a.Integrity != b.Integrity {
+			t.Errorf("%s: streamed File, Hash, Integrity = %s, %s, %s, want %s, %s, %s", a.Name, a.File, a.Hash, a.Integrity, b.File, b.Hash, b.Integrity)
+		}
+		if a.Content != nil {
+			t.Errorf("%s: Content = %q, want nil", a.Name, a.Content)
+		}
+		path := filepath.Join(outDir, a.File)
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(b.Content) {
+			t.Errorf("%s: file = %q, want %q", a.Name, got, b.Content)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err = Build(tmpl, nil, outDir, "/static", WithFingerprint(), WithStreamingWrites())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		if !a.ModTime.Equal(old) {
+			t.Errorf("%s: ModTime = %v after unchanged rebuild, want %v", a.Name, a.ModTime, old)
+		}
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(res.Assets) {
+		t.Errorf("outputDir has %d entries, want %d; temporary files left behind?", len(entries), len(res.Assets))
+	}
+
+	// A render error leaves no temporary file behind.
+	bad := template.Must(template.New("test").Parse(`{{define "static-js-bad"}}a{{.Missing.Field}}{{end}}`))
+	failDir := t.TempDir()
+	if _, err := Build(bad, struct{}{}, failDir, "/static", WithStreamingWrites()); err == nil {
+		t.Fatal("expected render error")
+	}
+	if entries, _ := os.ReadDir(failDir); len(entries) != 0 {
+		t.Errorf("failed build left %v", entries)
+	}
+}
+
+func TestBuildContent(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithMinifier(stripSpaces), WithInline("static-js-app"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := map[string]string{
+		"static-css-main": "body{color:red;}",
+		"static-js-app":   `console.log("hi");`,
+	}
+	if len(res.Assets) != len(want) {
+		t.Fatalf("got %d assets, want %d", len(res.Assets), len(want))
+	}
+	for _, a := range res.Assets {
+		if string(a.Content) != want[a.Name] {
+			t.Errorf("%s: Content = %q, want %q", a.Name, a.Content, want[a.Name])
+		}
+		if a.File == "" {
+			continue
+		}
+		written, err := os.ReadFile(filepath.Join(outDir, a.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written, a.Content) {
+			t.Errorf("%s: Content differs from the file: %q vs %q", a.Name, a.Content, written)
+		}
+	}
+}
+
+func TestWithKinds(t *testing.T) {
+	const tmplStr = `{{define "vendor"}}body { color: blue; }{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, nil, outDir, "/static", WithKinds(map[string]Kind{"vendor": KindCSS}))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "vendor.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "body { color: blue; }"; string(got) != want {
+		t.Errorf("vendor.css = %q, want %q", got, want)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if want := `<link rel="stylesheet" href="/static/vendor.css">`; !strings.Contains(buf.String(), want) {
+		t.Errorf("output missing %s\ngot: %s", want, buf.String())
+	}
+
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithKinds(map[string]Kind{"vendor": "sass"})); err == nil {
+		t.Error("WithKinds with an unknown kind: expected error")
+	}
+}
+
+// Parsing a result again would find its statics redefined to their tags.
+func TestParseResultAgain(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	_, err = Parse(rt, nil, t.TempDir(), "/static")
+	if err == nil || !strings.Contains(err.Error(), "already the result of Parse") {
+		t.Errorf("err = %v, want an already-processed error", err)
+	}
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static"); err != nil {
+		t.Errorf("Parse of the original again: %v", err)
+	}
+}
+
+// Extract writes the same files Build would and leaves t usable.
+func TestExtract(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	assets, err := Extract(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	res, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build after Extract: %v", err)
+	}
+	if len(assets) != len(res.Assets) {
+		t.Fatalf("got %d assets, want %d", len(assets), len(res.Assets))
+	}
+	for i, a := range assets {
+		if a.URL != res.Assets[i].URL {
+			t.Errorf("%s: URL = %q, want %q", a.Name, a.URL, res.Assets[i].URL)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, a.File)); err != nil {
+			t.Errorf("%s: %v", a.Name, err)
+		}
+	}
+}
+
+func TestWithWriteObserver(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	var written, skipped []string
+	observe := WithWriteObserver(
+		func(a AssetInfo) { written = append(written, a.Name) },
+		func(a AssetInfo) { skipped = append(skipped, a.Name) },
+	)
+	for run, want := range []struct{ written, skipped int }{{2, 0}, {0, 2}} {
+		written, skipped = nil, nil
+		if _, err := Build(tmpl, nil, outDir, "/static", observe); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		if len(written) != want.written || len(skipped) != want.skipped {
+			t.Errorf("run %d: written %q, skipped %q; want %d and %d", run+1, written, skipped, want.written, want.skipped)
+		}
+	}
+}
+
+// A relative outputDir is resolved against the working directory at the time
+// of the call and reported as an absolute path.
+func TestBuildOutputDirAbsolute(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	root := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	res, err := Build(tmpl, nil, "static", "/static", WithDestination(KindJS, "js", "/js"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if want := filepath.Join(root, "static"); res.OutputDir != want {
+		t.Errorf("OutputDir = %s, want %s", res.OutputDir, want)
+	}
+	for _, a := range res.Assets {
+		if !filepath.IsAbs(a.Dir) {
+			t.Errorf("%s: Dir = %s, want an absolute path", a.Name, a.Dir)
+		}
+		if _, err := os.Stat(filepath.Join(a.Dir, a.File)); err != nil {
+			t.Errorf("%s: %v", a.Name, err)
+		}
+	}
+}
+
+func TestBuildETag(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+
+	res1, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	res2, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if len(res1.Assets) != 2 {
+		t.Fatalf("got %d assets, want 2", len(res1.Assets))
+	}
+	etag := regexp.MustCompile(`^"[0-9a-f]{64}"$`)
+	for i, a := range res1.Assets {
+		if !etag.MatchString(a.ETag) {
+			t.Errorf("%s: ETag = %s, want quoted hex SHA-256", a.Name, a.ETag)
+		}
+		if a.ETag != `"`+a.Hash+`"` {
+			t.Errorf("%s: ETag %s not derived from Hash %s", a.Name, a.ETag, a.Hash)
+		}
+		if b := res2.Assets[i]; a.ETag != b.ETag {
+			t.Errorf("%s: ETag not stable across runs: %s vs %s", a.Name, a.ETag, b.ETag)
+		}
+	}
+
+	css := res1.Assets[0]
+	if css.Name != "static-css-main" || css.File != "main.css" || css.URL != "/static/main.css" {
+		t.Errorf("unexpected CSS asset %+v", css)
+	}
+}
+
+func TestBuildNearMisses(t *testing.T) {
+	const tmplStr = `{{define "static-cs-main"}}a{{end}}
+{{define "Static-JS-app"}}b{{end}}
+{{define "statc-css-x"}}c{{end}}
+{{define "static-css-ok"}}d{{end}}
+{{define "static-header"}}e{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+
+	res, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if res.NearMisses != nil {
+		t.Errorf("NearMisses without option = %v, want nil", res.NearMisses)
+	}
+
+	res, err = Build(tmpl, nil, t.TempDir(), "/static", WithNearMissDetection())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := []string{"Static-JS-app", "statc-css-x", "static-cs-main"}
+	if !slices.Equal(res.NearMisses, want) {
+		t.Errorf("NearMisses = %v, want %v", res.NearMisses, want)
+	}
+
+	outDir := t.TempDir()
+	if _, err := Build(tmpl, nil, outDir, "/static", WithStrictNearMisses()); err == nil {
+		t.Error("WithStrictNearMisses: expected error")
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("WithStrictNearMisses wrote %d files, want 0", len(entries))
+	}
+}
+
+func TestBuildAllowedRoot(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Build(tmpl, nil, filepath.Join(root, "static"), "/static", WithAllowedRoot(root)); err != nil {
+		t.Errorf("in-root outputDir: %v", err)
+	}
+
+	escaping := filepath.Join(root, "..", "other")
+	if _, err := Build(tmpl, nil, escaping, "/static", WithAllowedRoot(root)); err == nil {
+		t.Error("escaping outputDir: expected error")
+	}
+	if _, err := os.Stat(escaping); err == nil {
+		t.Error("escaping outputDir was created")
+	}
+
+	// So does a manifest, whether relative or absolute.
+	for _, name := range []string{"../../escaped.json", filepath.Join(base, "escaped.json")} {
+		if _, err := Build(tmpl, nil, filepath.Join(root, "static"), "/static", WithAllowedRoot(root), WithManifest(name)); err == nil {
+			t.Errorf("WithManifest(%q): expected error", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, "escaped.json")); err == nil {
+		t.Error("escaping manifest was written")
+	}
+	if _, err := Build(tmpl, nil, filepath.Join(root, "static"), "/static", WithAllowedRoot(root), WithChecksums("../../SUMS")); err == nil {
+		t.Error("escaping checksums file: expected error")
+	}
+	if _, err := os.Stat(filepath.Join(base, "SUMS")); err == nil {
+		t.Error("escaping checksums file was written")
+	}
+	if _, err := Build(tmpl, nil, filepath.Join(root, "static"), "/static", WithAllowedRoot(root), WithGoConstants("../../urls.go", "assets")); err == nil {
+		t.Error("escaping Go constants file: expected error")
+	}
+	if _, err := os.Stat(filepath.Join(base, "urls.go")); err == nil {
+		t.Error("escaping Go constants file was written")
+	}
+
+	// A symlink inside root that points outside it also escapes.
+	if err := os.Symlink(base, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if _, err := Build(tmpl, nil, filepath.Join(root, "link", "static"), "/static", WithAllowedRoot(root)); err == nil {
+		t.Error("symlinked outputDir: expected error")
+	}
+}
+
+func TestBuildContextCancelled(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	outDir := t.TempDir()
+	_, err := BuildContext(ctx, tmpl, nil, outDir, "/static")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("BuildContext error = %v, want context.Canceled", err)
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("cancelled build wrote %d files, want 0", len(entries))
+	}
+}
+
+func TestParseContextStopsRendering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Each definition cancels the context as it renders, so only the first
+	// one rendered should run.
+	renders := 0
+	funcs := template.FuncMap{"work": func() string {
+		renders++
+		cancel()
+		return ""
+	}}
+	const tmplStr = `{{define "static-css-a"}}{{work}}{{end}}
+{{define "static-css-b"}}{{work}}{{end}}
+{{define "static-js-c"}}{{work}}{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(funcs).Parse(tmplStr))
+
+	_, err := ParseContext(ctx, tmpl, nil, t.TempDir(), "/static")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ParseContext error = %v, want context.Canceled", err)
+	}
+	if renders != 1 {
+		t.Errorf("rendered %d definitions after cancel, want 1", renders)
+	}
+}
+
+// Render and write errors name the static they came from, even when the
+// failing expression is in a template it calls.
+func TestBuildErrorsNameStatic(t *testing.T) {
+	funcs := template.FuncMap{"fail": func() (string, error) { return "", errors.New("boom") }}
+	const tmplStr = `{{define "helper"}}{{fail}}{{end}}
+{{define "static-css-bad"}}{{template "helper"}}{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(funcs).Parse(tmplStr))
+	_, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err == nil || !strings.Contains(err.Error(), `rendering "static-css-bad"`) {
+		t.Errorf("render error = %v, want it to name static-css-bad", err)
+	}
+
+	// A directory where the file should go makes the write fail.
+	outDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(outDir, "main.css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	good := template.Must(template.New("test").Parse(testTemplateAuto))
+	_, err = Build(good, nil, outDir, "/static")
+	if err == nil || !strings.Contains(err.Error(), `writing "static-css-main" to main.css`) {
+		t.Errorf("write error = %v, want it to name static-css-main and main.css", err)
+	}
+}
+
+func TestParseUndefinedStatic(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{}{{end}}
+{{define "page"}}<html><head>{{template "static-css-main"}}{{template "static-css-missing"}}</head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	_, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err == nil || !strings.Contains(err.Error(), `"page" calls {{template "static-css-missing"}}, which is not defined`) {
+		t.Errorf("err = %v, want it to name page and static-css-missing", err)
+	}
+
+	// A bundle has no definition of its own.
+	const bundleStr = `{{define "static-css-a"}}a{}{{end}}
+{{define "page"}}<html><head>{{template "static-css-all"}}</head></html>{{end}}`
+	tmpl = template.Must(template.New("test").Parse(bundleStr))
+	if _, err := Parse(tmpl, nil, t.TempDir(), "/static", WithBundle("static-css-all", "static-css-a")); err != nil {
+		t.Errorf("Parse with bundle: %v", err)
+	}
+}
+
+func TestBuildAllErrors(t *testing.T) {
+	funcs := template.FuncMap{"fail": func() (string, error) { return "", errors.New("boom") }}
+	const tmplStr = `{{define "static-css-bad"}}{{fail}}{{end}}
+{{define "static-js-broken"}}{{fail}}{{end}}
+{{define "static-css-good"}}ok{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(funcs).Parse(tmplStr))
+
+	// Default is fail-fast: one error.
+	_, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err == nil {
+		t.Fatal("Build: expected error")
+	}
+	if n := strings.Count(err.Error(), "boom"); n != 1 {
+		t.Errorf("fail-fast error reports %d failures, want 1: %v", n, err)
+	}
+
+	outDir := t.TempDir()
+	_, err = Build(tmpl, nil, outDir, "/static", WithAllErrors())
+	if err == nil {
+		t.Fatal("Build with WithAllErrors: expected error")
+	}
+	for _, name := range []string{"static-css-bad", "static-js-broken"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error does not mention %s: %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "static-css-good") {
+		t.Errorf("error mentions successful definition: %v", err)
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("failed build wrote %d files, want 0", len(entries))
+	}
+}
+
+// Auto-injected definitions are emptied in the result, not left with their
+// original content.
+func TestParseEmptiesAutoInjected(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "static-css-main", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("static-css-main rendered %q, want empty", buf.String())
+	}
+
+	buf.Reset()
+	if err := tmpl.ExecuteTemplate(&buf, "static-css-main", nil); err != nil {
+		t.Fatalf("original ExecuteTemplate: %v", err)
+	}
+	if got := buf.String(); got != "body { color: red; }" {
+		t.Errorf("original static-css-main rendered %q after Parse", got)
+	}
+}
+
+func TestAssetFunc(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{{end}}
+{{define "page"}}<a href="{{asset "main.css"}}">{{end}}
+{{define "missing"}}{{asset "nope.css"}}{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(FuncMap()).Parse(tmplStr))
+
+	// The placeholder fails until Parse replaces it.
+	orig := template.Must(tmpl.Clone())
+	if err := orig.ExecuteTemplate(new(bytes.Buffer), "page", nil); err == nil {
+		t.Error("placeholder asset: expected error")
+	}
+
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithFingerprint())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if !regexp.MustCompile(`^<a href="/static/main\.[0-9a-f]{8}\.css">$`).Match(buf.Bytes()) {
+		t.Errorf("output = %s, want fingerprinted URL", buf.String())
+	}
+
+	if err := rt.ExecuteTemplate(new(bytes.Buffer), "missing", nil); err == nil {
+		t.Error("unknown asset name: expected error")
+	}
+}
+
+func TestBuildFilenameCollision(t *testing.T) {
+	const tmplStr = `{{define "static-css-Main"}}a{{end}}
+{{define "static-css-main"}}b{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	outDir := t.TempDir()
+	_, err := Build(tmpl, nil, outDir, "/static")
+	if err == nil {
+		t.Fatal("expected collision error")
+	}
+	for _, name := range []string{"static-css-Main", "static-css-main"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error does not name %s: %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("colliding build wrote %d files, want 0", len(entries))
+	}
+
+	tmpl = template.Must(template.New("test").Parse(`{{define "static-js-app"}}a{{end}}`))
+	if _, err := Build(tmpl, nil, t.TempDir(), "/static", WithManifest("app.js")); err == nil {
+		t.Error("manifest colliding with asset: expected error")
+	}
+}
+
+func TestParseRepeatedNoRewrite(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	if _, err := Parse(tmpl, nil, outDir, "/static"); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"main.css", "app.js"} {
+		if err := os.Chtimes(filepath.Join(outDir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := Parse(tmpl, nil, outDir, "/static"); err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+	}
+	for _, name := range []string{"main.css", "app.js"} {
+		info, err := os.Stat(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("%s rewritten by an unchanged Parse", name)
+		}
+	}
+}
+
+// An unchanged rebuild with every output enabled must not touch the output
+// tree at all: not the assets, source maps, or manifest, nor the directory
+// itself.
+func TestBuildUnchangedZeroWrites(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir := t.TempDir()
+	opts := []Option{WithFingerprint(), WithManifest("manifest.json"), WithMinifier(stripSpaces), WithSourceMaps()}
+	if _, err := Build(tmpl, nil, outDir, "/static", opts...); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var paths []string
+	err := filepath.WalkDir(outDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Backdate children before their directories so the latter stick.
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := os.Chtimes(paths[i], old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(paths) != 6 {
+		t.Fatalf("output tree has %d entries, want 6 (dir, 2 assets, 2 maps, manifest)", len(paths))
+	}
+
+	if _, err := Build(tmpl, nil, outDir, "/static", opts...); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("%s modified by an unchanged Build", path)
+		}
+	}
+}
+
+// Rendering a previous result must be unaffected by later Parse calls on the
+// same source template. Run with -race.
+func TestParseConcurrentWithRender(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateExplicit))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, nil, outDir, "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var want bytes.Buffer
+	if err := rt.ExecuteTemplate(&want, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var buf bytes.Buffer
+				if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+					t.Error(err)
+					return
+				}
+				if buf.String() != want.String() {
+					t.Errorf("concurrent render changed:\n%s\nwant:\n%s", buf.String(), want.String())
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		rt2, err := Parse(tmpl, nil, outDir, "/static", WithFingerprint())
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if rt2 == rt {
+			t.Fatal("Parse returned the same template twice")
+		}
+		if err := rt2.ExecuteTemplate(new(bytes.Buffer), "page", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestBuildPlaced(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateExplicit))
+	res, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := map[string]bool{"static-css-critical": true, "static-js-app": false}
+	for _, a := range res.Assets {
+		if a.Placed != want[a.Name] {
+			t.Errorf("%s: Placed = %v, want %v", a.Name, a.Placed, want[a.Name])
+		}
+	}
+
+	res, err = Build(tmpl, nil, t.TempDir(), "/static", WithoutInjection())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		if a.Placed {
+			t.Errorf("%s: Placed with WithoutInjection", a.Name)
+		}
+	}
+}
+
+func TestParsePlacementInRange(t *testing.T) {
+	const src = `{{define "static-css-item"}}li { color: red; }{{end}}
+{{define "static-css-top"}}body { margin: 0; }{{end}}
+{{define "page"}}<html><head>
+</head><body>{{template "static-css-top"}}<ul>{{range .}}{{if .}}{{template "static-css-item"}}{{end}}<li>{{.}}</li>{{else}}{{template "static-css-item"}}{{end}}</ul></body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(src))
+	res, err := Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, a := range res.Assets {
+		if a.Name == "static-css-item" && !a.Placed {
+			t.Errorf("static-css-item: not placed despite a call in the range's else branch")
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, `href="/static/item.css"`); n != 0 {
+		t.Errorf("item tag rendered %d times in a non-empty range, want 0 (placed only in else)\n%s", n, out)
+	}
+	if n := strings.Count(out, `href="/static/top.css"`); n != 1 {
+		t.Errorf("top tag rendered %d times, want 1\n%s", n, out)
+	}
+
+	// With the else branch gone, a loop-only call falls back to head injection.
+	const loopOnly = `{{define "static-css-item"}}li { color: red; }{{end}}
+{{define "page"}}<html><head>
+</head><body><ul>{{range .}}{{with .}}{{template "static-css-item"}}{{end}}<li>{{.}}</li>{{end}}</ul></body></html>{{end}}`
+	tmpl = template.Must(template.New("test").Parse(loopOnly))
+	res, err = Build(tmpl, nil, t.TempDir(), "/static")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if res.Assets[0].Placed {
+		t.Error("loop-only call reported as placed")
+	}
+	buf.Reset()
+	if err := res.Template.ExecuteTemplate(&buf, "page", []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out = buf.String()
+	if n := strings.Count(out, `href="/static/item.css"`); n != 1 {
+		t.Errorf("item tag rendered %d times, want 1\n%s", n, out)
+	}
+	if i, j := strings.Index(out, "item.css"), strings.Index(out, "</head>"); i < 0 || i > j {
+		t.Errorf("item tag not injected into head\n%s", out)
+	}
+}
+
+func TestWithPlaceOnce(t *testing.T) {
+	const src = `{{define "static-css-main"}}body { margin: 0; }{{end}}
+{{define "header"}}{{template "static-css-main"}}{{end}}
+{{define "page"}}<html><head>{{template "static-css-main"}}
+</head><body>{{template "header"}}{{template "static-css-main"}}</body></html>{{end}}`
+	tests := []struct {
+		opts []Option
+		want int
+	}{
+		{nil, 3},
+		{[]Option{WithPlaceOnce()}, 1},
+	}
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("test").Parse(src))
+		rt, err := Parse(tmpl, nil, t.TempDir(), "/static", tt.opts...)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		if n := strings.Count(buf.String(), `href="/static/main.css"`); n != tt.want {
+			t.Errorf("opts %d: tag rendered %d times, want %d\n%s", len(tt.opts), n, tt.want, buf.String())
+		}
+	}
+}
+
+func TestWithSourceDefinitions(t *testing.T) {
+	const src = `{{define "static-css-main"}}body { color: {{.}}; }{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(src))
+	outDir := t.TempDir()
+	rt, err := Parse(tmpl, "red", outDir, "/static", WithSourceDefinitions())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "static-css-main-source", "blue"); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if got, want := buf.String(), "body { color: blue; }"; got != want {
+		t.Errorf("source = %q, want %q", got, want)
+	}
+	buf.Reset()
+	if err := rt.ExecuteTemplate(&buf, "static-css-main", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("static-css-main = %q, want it auto-injected and empty", buf.String())
+	}
+
+	rt, err = Parse(tmpl, "red", outDir, "/static")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if rt.Lookup("static-css-main-source") != nil {
+		t.Error("source kept without WithSourceDefinitions")
+	}
+
+	clash := template.Must(template.Must(tmpl.Clone()).Parse(`{{define "static-css-main-source"}}x{{end}}`))
+	if _, err := Parse(clash, "red", outDir, "/static", WithSourceDefinitions()); err == nil {
+		t.Error("expected error for an existing -source name")
+	}
+}
+
+func TestWithFilenameTemplate(t *testing.T) {
+	const src = `{{define "static-js-app"}}run();{{end}}
+{{define "static-css-main"}}a{}{{end}}
+{{define "page"}}<html><head></head><body>{{asset "app.js"}}</body></html>{{end}}`
+	data := struct{ Version string }{"3"}
+	tmpl := template.Must(template.New("test").Funcs(FuncMap()).Parse(src))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, data, outDir, "/static", WithFilenameTemplate("static-js-app", "app-{{.Version}}"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "app-3.js")); err != nil {
+		t.Errorf("app-3.js not written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "main.css")); err != nil {
+		t.Errorf("main.css not written: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{`<script src="/static/app-3.js"></script>`, `<body>/static/app-3.js</body>`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %s\ngot: %s", want, out)
+		}
+	}
+
+	for name, opts := range map[string][]Option{
+		"missing field": {WithFilenameTemplate("static-js-app", "app-{{.Nope}}")},
+		"syntax":        {WithFilenameTemplate("static-js-app", "app-{{")},
+		"escapes":       {WithFilenameTemplate("static-js-app", "../{{.Version}}")},
+		"collision":     {WithFilenameTemplate("static-css-main", "app"), WithCSSExtension(".js")},
+	} {
+		if _, err := Build(tmpl, data, t.TempDir(), "/static", opts...); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseExecutedTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	if err := tmpl.ExecuteTemplate(io.Discard, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	_, err := Parse(tmpl, nil, t.TempDir(), "/static")
+	if err == nil || !strings.Contains(err.Error(), "already been executed") {
+		t.Errorf("Parse error = %v, want one explaining the template was executed", err)
+	}
+}
+
+func TestWithoutStaticDefinitions(t *testing.T) {
+	funcs := template.FuncMap{"shout": strings.ToUpper}
+	const src = `{{define "static-css-critical"}}h1{}{{end}}
+{{define "static-css-main"}}body{}{{end}}
+{{define "static-js-app"}}go(){{end}}
+{{define "page"}}<html><head>{{template "static-css-critical"}}
+</head><body>{{shout "hi"}} {{asset "app.js"}}</body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(FuncMap()).Funcs(funcs).Parse(src))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithoutStaticDefinitions(funcs))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for name, want := range map[string]bool{
+		"static-css-critical": true,
+		"static-css-main":     false,
+		"static-js-app":       false,
+		"page":                true,
+		"test":                true,
+	} {
+		if got := rt.Lookup(name) != nil; got != want {
+			t.Errorf("Lookup(%q) present = %v, want %v", name, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	want := `<html><head><link rel="stylesheet" href="/static/critical.css">
+  <link rel="stylesheet" href="/static/main.css">
+  <script src="/static/app.js"></script>
+</head><body>HI /static/app.js</body></html>`
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// benchTemplateSet returns a set of n page templates whose bodies nest
+// {{range}}/{{with}}/{{if}} depth levels deep, plus statics placed in some.
+func benchTemplateSet(n, depth int) *template.Template {
+	var b strings.Builder
+	b.WriteString(`{{define "static-css-main"}}body{}{{end}}{{define "static-js-app"}}go(){{end}}`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `{{define "page%d"}}<html><head>`, i)
+		if i == n-1 {
+			b.WriteString(`{{template "static-css-main"}}`)
+		}
+		b.WriteString(`</head><body>`)
+		for d := 0; d < depth; d++ {
+			b.WriteString([]string{`{{range .}}<ul>`, `{{with .}}<li>`, `{{if .}}<p>`}[d%3])
+		}
+		b.WriteString(`{{.}}`)
+		for d := depth - 1; d >= 0; d-- {
+			b.WriteString([]string{`</ul>{{end}}`, `</li>{{end}}`, `</p>{{else}}-{{end}}`}[d%3])
+		}
+		b.WriteString(`</body></html>{{end}}`)
+	}
+	return template.Must(template.New("bench").Parse(b.String()))
+}
+
+func TestFindPlacedStatics(t *testing.T) {
+	const tmplStr = `{{define "static-css-main"}}a{{end}}
+{{define "static-css-extra"}}b{{end}}
+{{define "static-js-app"}}c(){{end}}
+{{define "vendor"}}d{{end}}
+{{define "page"}}<head>{{template "static-js-app"}}{{template "vendor"}}</head>{{end}}
+{{define "other"}}{{template "static-css-main"}}{{template "greeting"}}{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	got := FindPlacedStatics(tmpl, WithKinds(map[string]Kind{"vendor": KindCSS}))
+	if want := []string{"static-css-main", "static-js-app", "vendor"}; !slices.Equal(got, want) {
+		t.Errorf("FindPlacedStatics = %q, want %q", got, want)
+	}
+}
+
+// On a 200-template set nested 30 deep, findPlacedTemplates is around 1% of
+// Parse, which is dominated by the two html/template Clones; compare these
+// two benchmarks before optimizing the tree walks.
+func BenchmarkFindPlacedTemplates(b *testing.B) {
+	tmpl := benchTemplateSet(200, 30)
+	cfg := newConfig(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findPlacedTemplates(tmpl, cfg)
+	}
+}
+
+func BenchmarkParseLargeSet(b *testing.B) {
+	tmpl := benchTemplateSet(200, 30)
+	outDir := b.TempDir()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(tmpl, nil, outDir, "/static"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// WithInPlace saves the clone of the result; compare with
+// BenchmarkParseLargeSet.
+func BenchmarkParseLargeSetInPlace(b *testing.B) {
+	outDir := b.TempDir()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tmpl := benchTemplateSet(200, 30)
+		b.StartTimer()
+		if _, err := Parse(tmpl, nil, outDir, "/static", WithInPlace()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWithInPlace(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	rt, err := Parse(tmpl, nil, t.TempDir(), "/static", WithInPlace())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if rt != tmpl {
+		t.Error("WithInPlace returned a different template")
+	}
+	var buf bytes.Buffer
+	if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	if want := `<link rel="stylesheet" href="/static/main.css">`; !strings.Contains(buf.String(), want) {
+		t.Errorf("output missing %s\ngot: %s", want, buf.String())
+	}
+}
+
+// In-place mode produces what the default clone-based mode does.
+func TestWithInPlaceMatchesClone(t *testing.T) {
+	for _, opts := range [][]Option{
+		nil,
+		{WithInline("static-css-main"), WithPlacement("static-js-app", AtBodyEnd)},
+		{WithSourceDefinitions(), WithFingerprint()},
+		{WithoutStaticDefinitions(nil), WithBuildComment("build 1")},
+	} {
+		var outs [2]string
+		for i, mode := range []Option{WithInPlace(), func(*config) {}} {
+			tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+			rt, err := Parse(tmpl, nil, t.TempDir(), "/static", append(opts, mode)...)
+			if err != nil {
+				t.Fatalf("%d opts: Parse: %v", len(opts), err)
+			}
+			var buf bytes.Buffer
+			if err := rt.ExecuteTemplate(&buf, "page", nil); err != nil {
+				t.Fatalf("%d opts: ExecuteTemplate: %v", len(opts), err)
+			}
+			outs[i] = buf.String()
+		}
+		if outs[0] != outs[1] {
+			t.Errorf("%d opts: in place:\n%s\nwith a clone:\n%s", len(opts), outs[0], outs[1])
+		}
+	}
+}
+
+func TestWithDestination(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testTemplateAuto))
+	outDir, jsDir := t.TempDir(), t.TempDir()
+	cssDir := filepath.Join(outDir, "css")
+	res, err := Build(tmpl, nil, outDir, "/static",
+		WithDestination(KindCSS, cssDir, "https://cdn.example.com/css/"),
+		WithDestination(KindJS, jsDir, "/js"),
+		WithManifest("manifest.json"))
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, path := range []string{filepath.Join(cssDir, "main.css"), filepath.Join(jsDir, "app.js"), filepath.Join(outDir, "manifest.json")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+	for _, path := range []string{filepath.Join(outDir, "main.css"), filepath.Join(outDir, "app.js")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s written to outputDir", path)
+		}
+	}
+	for _, a := range res.Assets {
+		want := map[Kind]string{KindCSS: cssDir, KindJS: jsDir}[a.Kind]
+		if a.Dir != want {
+			t.Errorf("%s: Dir = %q, want %q", a.Name, a.Dir, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, want := range []string{
+		`<link rel="stylesheet" href="https://cdn.example.com/css/main.css" integrity="sha384-`,
+		`<script src="/js/app.js"></script>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+
+	// Collisions are per directory: the same file name in one shared
+	// directory is an error.
+	shared := t.TempDir()
+	_, err = Build(tmpl, nil, outDir, "/static",
+		WithDestination(KindCSS, shared, "/a"), WithDestination(KindJS, shared, "/a"),
+		WithCSSExtension(".txt"), WithJSExtension(".txt"), WithFilenameTemplate("static-js-app", "main"))
+	if err == nil {
+		t.Error("expected a collision error for a shared directory")
+	}
+}
+
+// Explicit calls and the injection point are found in every kind of branch,
+// including else-if and else-with chains and nested ifs.
+func TestParseBranches(t *testing.T) {
+	const defs = `{{define "static-css-x"}}x{}{{end}}{{define "static-js-y"}}y(){{end}}`
+	const css = `<link rel="stylesheet" href="/static/x.css">`
+	tests := []struct {
+		name, page string
+		data       any
+		want       string
+	}{
+		{"else", `{{if .}}A{{else}}{{template "static-css-x"}}{{end}}<head></head>`, false, css},
+		{"else if", `{{if eq . 1}}A{{else if eq . 2}}{{template "static-css-x"}}{{else}}C{{end}}<head></head>`, 2, css},
+		{"else if chain", `{{if eq . 1}}A{{else if eq . 2}}B{{else if eq . 3}}{{template "static-css-x"}}{{end}}<head></head>`, 3, css},
+		{"else with", `{{with .A}}A{{else with .B}}{{template "static-css-x"}}{{end}}<head></head>`, map[string]int{"B": 1}, css},
+		{"nested if", `{{if .}}{{if .}}{{if .}}{{template "static-css-x"}}{{end}}{{end}}{{end}}<head></head>`, true, css},
+		{"range else", `{{range .}}-{{else}}{{template "static-css-x"}}{{end}}<head></head>`, []int{}, css},
+		{"head in else if", `{{if eq . 1}}A{{else if eq . 2}}<head></head>{{end}}`, 2, css},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("page").Parse(defs + tt.page))
+			res, err := Build(tmpl, nil, t.TempDir(), "/static")
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := res.Template.Execute(&buf, tt.data); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			out := buf.String()
+			if n := strings.Count(out, tt.want); n != 1 {
+				t.Errorf("css tag rendered %d times, want 1\n%s", n, out)
+			}
+			if !strings.Contains(out, "y.js") {
+				t.Errorf("js tag not injected\n%s", out)
+			}
+			placed := !strings.HasPrefix(tt.name, "head")
+			if got := res.Assets[0].Placed; got != placed {
+				t.Errorf("static-css-x Placed = %v, want %v", got, placed)
+			}
+		})
+	}
+}
+
+func TestWithKindDirs(t *testing.T) {
+	const src = `{{define "static-css-main"}}body { }{{end}}
+{{define "static-js-app"}}go(){{end}}
+{{define "page"}}<html><head></head><body>{{asset "main.css"}}</body></html>{{end}}`
+	tmpl := template.Must(template.New("test").Funcs(FuncMap()).Parse(src))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithCSSDir("css"), WithJSDir("assets/js"),
+		WithMinifier(stripSpaces), WithSourceMaps())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, file := range []string{"css/main.css", "css/main.css.map", "assets/js/app.js", "assets/js/app.js.map"} {
+		if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(file))); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+	for _, a := range res.Assets {
+		want := map[string]string{"static-css-main": "main.css", "static-js-app": "app.js"}[a.Name]
+		if a.Logical != want {
+			t.Errorf("%s: Logical = %q, want %q", a.Name, a.Logical, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, want := range []string{
+		`<link rel="stylesheet" href="/static/css/main.css">`,
+		`<script src="/static/assets/js/app.js"></script>`,
+		`<body>/static/css/main.css</body>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %s\ngot: %s", want, buf.String())
+		}
+	}
+
+	if _, err := Build(tmpl, nil, outDir, "/static", WithCSSDir("../css")); err == nil {
+		t.Error("expected error for a directory outside outputDir")
+	}
+
+	// WithKindDirs names each directory after its kind, unless set explicitly.
+	for _, tt := range []struct {
+		opts    []Option
+		css, js string
+	}{
+		{[]Option{WithKindDirs()}, "css/main.css", "js/app.js"},
+		{[]Option{WithJSDir("scripts"), WithKindDirs()}, "css/main.css", "scripts/app.js"},
+		{[]Option{WithKindDirs(), WithJSDir("scripts")}, "css/main.css", "scripts/app.js"},
+	} {
+		outDir := t.TempDir()
+		res, err := Build(tmpl, nil, outDir, "/static", tt.opts...)
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		for _, file := range []string{tt.css, tt.js} {
+			if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(file))); err != nil {
+				t.Errorf("%v", err)
+			}
+		}
+		buf.Reset()
+		if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+			t.Fatalf("ExecuteTemplate: %v", err)
+		}
+		for _, want := range []string{
+			`<link rel="stylesheet" href="/static/` + tt.css + `">`,
+			`<script src="/static/` + tt.js + `"></script>`,
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("output missing %s\ngot: %s", want, buf.String())
+			}
+		}
+	}
+}
+
+func TestWithDedupe(t *testing.T) {
+	const src = `{{define "static-css-a"}}body{}{{end}}
+{{define "static-css-b"}}body{}{{end}}
+{{define "static-css-c"}}p{}{{end}}
+{{define "static-js-d"}}body{}{{end}}
+{{define "page"}}<html><head></head></html>{{end}}`
+	tmpl := template.Must(template.New("test").Parse(src))
+	outDir := t.TempDir()
+	res, err := Build(tmpl, nil, outDir, "/static", WithFingerprint(), WithDedupe())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("wrote %d files, want 3 (a/b shared, c, and d of another kind)", len(entries))
+	}
+	files := make(map[string]string)
+	for _, a := range res.Assets {
+		files[a.Name] = a.File
+	}
+	if files["static-css-a"] != files["static-css-b"] || !strings.HasPrefix(files["static-css-a"], "a.") {
+		t.Errorf("a and b files = %q, %q, want both a's", files["static-css-a"], files["static-css-b"])
+	}
+	if files["static-js-d"] == files["static-css-a"] {
+		t.Error("content shared across kinds")
+	}
+
+	var buf bytes.Buffer
+	if err := res.Template.ExecuteTemplate(&buf, "page", nil); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	tag := `<link rel="stylesheet" href="/static/` + files["static-css-a"] + `">`
+	if n := strings.Count(buf.String(), tag); n != 2 {
+		t.Errorf("shared tag rendered %d times, want 2\n%s", n, buf.String())
+	}
+
+	// Without fingerprinting there is no dedupe.
+	outDir = t.TempDir()
+	if _, err := Build(tmpl, nil, outDir, "/static", WithDedupe()); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 4 {
+		t.Errorf("wrote %d files without fingerprinting, want 4", len(entries))
+	}
+}
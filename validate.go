@@ -0,0 +1,84 @@
+package templatestatic
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// A Validator checks the final content of the named static before anything
+// is written, returning an error to abort the build. See WithValidator.
+type Validator func(name string, kind Kind, content []byte) error
+
+// leakedAction matches what looks like a template action that reached the
+// output unexecuted, such as {{.Foo}} or {{ template "x" }}.
+var leakedAction = regexp.MustCompile(`\{\{-?\s*[.$A-Za-z"][^{}\n]*\}\}`)
+
+// ValidateSyntax is a Validator for mistakes that are easy to make in a
+// template and hard to spot in its output: text that looks like an
+// unexecuted {{action}}, in any kind, and in CSS, braces, brackets, and
+// parentheses that do not balance outside strings and comments. It is a
+// plausibility check, not a parser.
+func ValidateSyntax(name string, kind Kind, content []byte) error {
+	if m := leakedAction.Find(content); m != nil {
+		return fmt.Errorf("content contains template action %q", m)
+	}
+	if kind == KindCSS {
+		return balancedCSS(content)
+	}
+	return nil
+}
+
+// ValidateJSON is a Validator that rejects web app manifests that are not
+// well-formed JSON. Other kinds pass.
+func ValidateJSON(name string, kind Kind, content []byte) error {
+	if kind != KindManifest {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(content, &v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return nil
+}
+
+// balancedCSS returns an error for the first bracket in content that is not
+// closed, or the first closing bracket that was not opened, skipping strings
+// and comments.
+func balancedCSS(content []byte) error {
+	var stack []byte
+	line := 1
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; c {
+		case '\n':
+			line++
+		case '"', '\'':
+			for i++; i < len(content) && content[i] != c && content[i] != '\n'; i++ {
+				if content[i] == '\\' {
+					i++
+				}
+			}
+		case '/':
+			if i+1 < len(content) && content[i+1] == '*' {
+				for i += 2; i+1 < len(content) && !(content[i] == '*' && content[i+1] == '/'); i++ {
+					if content[i] == '\n' {
+						line++
+					}
+				}
+				i++
+			}
+		case '{', '(', '[':
+			stack = append(stack, c)
+		case '}', ')', ']':
+			open := map[byte]byte{'}': '{', ')': '(', ']': '['}[c]
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return fmt.Errorf("unbalanced %q on line %d", c, line)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("unclosed %q at end of content", stack[len(stack)-1])
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package templatestatic
+
+import (
+	"errors"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSyntax(t *testing.T) {
+	tests := []struct {
+		kind    Kind
+		content string
+		err     string // substring of the error, or "" for none
+	}{
+		{KindCSS, `a { color: red; } @media (x) { b { c: d } }`, ""},
+		{KindCSS, `a::after { content: "}"; } /* { */ b[x="]"] {}`, ""},
+		{KindCSS, `a { color: {{.Color}}; }`, `template action "{{.Color}}"`},
+		{KindJS, `var u = "{{ .URL }}";`, `template action "{{ .URL }}"`},
+		{KindJS, `if (a) {{}}`, ""},
+		{KindJS, `function f() { return "{" }`, ""},
+		{KindCSS, "a {\n  b: c;\n", `unclosed '{'`},
+		{KindCSS, "a { }\n}", `unbalanced '}' on line 2`},
+		{KindCSS, `a { b: calc(1px + (2px); }`, `unbalanced '}'`},
+	}
+	for _, tt := range tests {
+		err := ValidateSyntax("static-x", tt.kind, []byte(tt.content))
+		if tt.err == "" && err != nil {
+			t.Errorf("%s %q: %v", tt.kind, tt.content, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s %q: err = %v, want %s", tt.kind, tt.content, err, tt.err)
+		}
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	if err := ValidateJSON("static-manifest-app", KindManifest, []byte(`{"name": "App"}`)); err != nil {
+		t.Errorf("valid manifest: %v", err)
+	}
+	if err := ValidateJSON("static-manifest-app", KindManifest, []byte(`{"name": "App",}`)); err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Errorf("trailing comma: err = %v, want invalid JSON", err)
+	}
+	if err := ValidateJSON("static-css-main", KindCSS, []byte(`a {}`)); err != nil {
+		t.Errorf("CSS: %v", err)
+	}
+}
+
+func TestWithValidator(t *testing.T) {
+	// The action is escaped in the template source, so it reaches the
+	// output as text.
+	const tmplStr = `{{define "static-css-main"}}a { color: red; }{{end}}
+{{define "static-js-app"}}var x = "{{"{{"}}.Foo}}";{{end}}`
+	tmpl := template.Must(template.New("test").Parse(tmplStr))
+	outDir := t.TempDir()
+	_, err := Build(tmpl, nil, outDir, "/static", WithValidator(ValidateSyntax))
+	if err == nil || !strings.Contains(err.Error(), `"static-js-app": content contains template action "{{.Foo}}"`) {
+		t.Errorf("err = %v, want it to name static-js-app and the action", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "main.css")); !os.IsNotExist(err) {
+		t.Errorf("main.css written by a failed build")
+	}
+
+	var seen []string
+	custom := func(name string, kind Kind, content []byte) error {
+		seen = append(seen, name)
+		if kind == KindJS {
+			return errors.New("no JS allowed")
+		}
+		return nil
+	}
+	good := template.Must(template.New("test").Parse(testTemplateAuto))
+	_, err = Build(good, nil, t.TempDir(), "/static", WithValidator(custom), WithAllErrors())
+	if err == nil || !strings.Contains(err.Error(), `"static-js-app": no JS allowed`) || len(seen) != 2 {
+		t.Errorf("err = %v, validated %v; want static-js-app rejected after both ran", err, seen)
+	}
+}
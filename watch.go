@@ -0,0 +1,102 @@
+package templatestatic
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// defaultWatchInterval is how often Watch reloads templates unless
+// WithWatchInterval says otherwise.
+const defaultWatchInterval = 500 * time.Millisecond
+
+// A WatchEvent is sent by Watch after each build: Result on success, or Err
+// if loading or building failed.
+type WatchEvent struct {
+	Result *Result
+	Err    error
+}
+
+// Watch builds the templates returned by load, as Build does, and rebuilds
+// them whenever they change, for use during development. It polls: load is
+// called again at each interval (see WithWatchInterval), and the result is
+// rebuilt only if the parsed source of any template differs from the last
+// load. Unchanged assets are not rewritten, so their mtimes stay put.
+//
+// The first build is sent before Watch returns, buffered on the channel;
+// Watch returns an error only if that first load fails. Later events are
+// sent as changes are seen, and a failing state is reported once until it
+// changes. The channel is closed when ctx is done.
+func Watch(ctx context.Context, load func() (*template.Template, error), data any, outputDir, urlPrefix string, opts ...Option) (<-chan WatchEvent, error) {
+	t, err := load()
+	if err != nil {
+		return nil, err
+	}
+	interval := newConfig(opts).watchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	build := func(t *template.Template) WatchEvent {
+		res, err := BuildContext(ctx, t, data, outputDir, urlPrefix, opts...)
+		return WatchEvent{Result: res, Err: err}
+	}
+
+	// Take the key before building: with WithInPlace, the build rewrites t.
+	last := sourceKey(t)
+	ch := make(chan WatchEvent, 1)
+	ch <- build(t)
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			t, err := load()
+			key := "\x00" + fmt.Sprint(err)
+			if err == nil {
+				key = sourceKey(t)
+			}
+			if key == last {
+				continue
+			}
+			last = key
+			ev := WatchEvent{Err: err}
+			if err == nil {
+				ev = build(t)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// sourceKey returns a string that changes whenever any template associated
+// with t is added, removed, or reparsed with different source.
+func sourceKey(t *template.Template) string {
+	var b strings.Builder
+	for _, tmpl := range sortedTemplates(t) {
+		b.WriteString(tmpl.Name())
+		b.WriteByte(0)
+		if tmpl.Tree != nil {
+			b.WriteString(tmpl.Tree.Root.String())
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
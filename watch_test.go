@@ -0,0 +1,123 @@
+package templatestatic
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	var mu sync.Mutex
+	src := `{{define "static-css-main"}}a{}{{end}}{{define "page"}}<head></head>{{end}}`
+	var loadErr error
+	set := func(s string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		src, loadErr = s, err
+	}
+	load := func() (*template.Template, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return template.New("test").Parse(src)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outDir := t.TempDir()
+	ch, err := Watch(ctx, load, nil, outDir, "/static", WithWatchInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	next := func() WatchEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Fatal("channel closed")
+			}
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return WatchEvent{}
+	}
+	readMain := func() string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(outDir, "main.css"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if ev := next(); ev.Err != nil || ev.Result == nil {
+		t.Fatalf("first event = %+v", ev)
+	}
+	if got := readMain(); got != "a{}" {
+		t.Errorf("main.css = %q, want a{}", got)
+	}
+
+	// No change, no event.
+	select {
+	case ev := <-ch:
+		t.Fatalf("event without a change: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	set(`{{define "static-css-main"}}b{}{{end}}{{define "page"}}<head></head>{{end}}`, nil)
+	if ev := next(); ev.Err != nil {
+		t.Fatalf("rebuild: %v", ev.Err)
+	}
+	if got := readMain(); got != "b{}" {
+		t.Errorf("main.css = %q, want b{}", got)
+	}
+
+	boom := errors.New("boom")
+	set("", boom)
+	if ev := next(); !errors.Is(ev.Err, boom) {
+		t.Fatalf("Err = %v, want %v", ev.Err, boom)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestWatchInPlace(t *testing.T) {
+	load := func() (*template.Template, error) {
+		return template.New("test").Parse(`{{define "static-css-main"}}a{}{{end}}{{define "page"}}<head></head>{{end}}`)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := Watch(ctx, load, nil, t.TempDir(), "/static", WithInPlace(), WithWatchInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if ev := <-ch; ev.Err != nil {
+		t.Fatalf("first event: %v", ev.Err)
+	}
+
+	// Rewriting the first template in place is not a change in its source.
+	select {
+	case ev := <-ch:
+		t.Fatalf("event without a change: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchInitialLoadError(t *testing.T) {
+	boom := errors.New("boom")
+	_, err := Watch(context.Background(), func() (*template.Template, error) { return nil, boom }, nil, t.TempDir(), "/static")
+	if !errors.Is(err, boom) {
+		t.Errorf("Watch error = %v, want %v", err, boom)
+	}
+}